@@ -1,10 +1,13 @@
 #!/bin/sh
 
-mkdir -p data && cd data
+APP_DIR=$(cd "$(dirname "$0")" && pwd)
+DATA_DIR=${XW_DATA_DIR:-${XW_HOME:-$APP_DIR}/data}
+
+mkdir -p "$DATA_DIR" && cd "$DATA_DIR"
 
 for file in notify.js notify.py; do
     if [ ! -e "$file" ] || [ -L "$file" ]; then
-        cp "../$file" .
+        cp "$APP_DIR/$file" .
     fi
 done
 printf "const notify = require('notify');\n\nnotify.sendNotify('标题', '内容');\n" > notify_sample.js
@@ -18,4 +21,4 @@ if [ -f "pip.txt" ]; then
     pip install --no-cache-dir $(cat pip.txt)
 fi
 
-cd .. && ./xuanwu
\ No newline at end of file
+cd "$APP_DIR" && ./xuanwu
\ No newline at end of file
@@ -0,0 +1,96 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"xuanwu/lib/flock"
+	"xuanwu/lib/pathutil"
+)
+
+// 命令行操作记录到配置修订中的用户名
+const cliUser = "cli"
+
+// 子命令说明
+const usageText = `用法: xuanwu [-hide] [-data-dir 目录] [命令]
+
+不带命令时启动web服务和定时任务
+
+命令:
+  passwd [-u 用户名] [-p 密码] [-reset-totp]
+                                  重置用户密码,默认为第一个管理员,未提供 -p 时从终端读取
+                                  -reset-totp 同时关闭两步验证
+  task list                         列出所有任务
+  task add -name 名称 -times 定时 -exec 命令 [-workdir 目录] [-disable]
+  task enable <名称>                启用任务
+  task disable <名称>               禁用任务
+  task run <名称>                   立即执行任务并输出结果
+  config validate                   检查配置是否有效
+  logs tail [-n 行数] [-f] <任务>   查看任务日志,main 为主日志
+`
+
+// Run 执行子命令,返回进程退出码
+func Run(args []string) int {
+	if len(args) == 0 {
+		fmt.Print(usageText)
+		return 2
+	}
+	switch args[0] {
+	case "passwd":
+		return cmdPasswd(args[1:])
+	case "task":
+		return cmdTask(args[1:])
+	case "config":
+		return cmdConfig(args[1:])
+	case "logs":
+		return cmdLogs(args[1:])
+	case "help", "-h", "--help":
+		fmt.Print(usageText)
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "未知命令: %s\n\n", args[0])
+	fmt.Print(usageText)
+	return 2
+}
+
+// 输出错误并返回退出码1
+func fail(format string, a ...interface{}) int {
+	fmt.Fprintf(os.Stderr, format+"\n", a...)
+	return 1
+}
+
+// 运行中服务的进程号,服务未运行时返回0,无法获取时返回-1
+func serverPid() int {
+	lockPath := pathutil.GetInstanceLockPath()
+	// 能拿到单实例锁说明服务没有运行
+	if lock, err := flock.Acquire(lockPath, 0); err == nil {
+		lock.Release()
+		return 0
+	}
+	data, err := os.ReadFile(lockPath)
+	if err != nil {
+		return -1
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return -1
+	}
+	return pid
+}
+
+// 通知正在运行的服务重新加载配置
+func notifyServer() {
+	pid := serverPid()
+	if pid == 0 {
+		return
+	}
+	if pid > 0 {
+		if process, err := os.FindProcess(pid); err == nil && process.Signal(syscall.SIGHUP) == nil {
+			fmt.Println("已通知运行中的服务重新加载配置")
+			return
+		}
+	}
+	fmt.Println("无法通知运行中的服务重新加载,请重启服务使修改生效")
+}
@@ -0,0 +1,158 @@
+package cli
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"xuanwu/config"
+	serve "xuanwu/gin"
+	"xuanwu/lib/pathutil"
+	"xuanwu/xuanwu"
+
+	"github.com/tidwall/gjson"
+)
+
+// 配置管理
+func cmdConfig(args []string) int {
+	if len(args) == 0 || args[0] != "validate" {
+		fmt.Print(usageText)
+		return 2
+	}
+	return configValidate()
+}
+
+// 检查配置,有错误时返回1,只有警告时返回0
+func configValidate() int {
+	var errs, warns []string
+
+	storage := config.StorageType()
+	fmt.Printf("存储后端: %s\n", storage)
+
+	var cfg gjson.Result
+	if storage == config.STORAGE_JSON {
+		jsonByte, err := os.ReadFile(pathutil.GetConfigPath())
+		if err != nil {
+			return fail("读取配置文件失败: %v", err)
+		}
+		if !gjson.ValidBytes(jsonByte) {
+			return fail("配置文件不是有效的json: %s", pathutil.GetConfigPath())
+		}
+		cfg = gjson.ParseBytes(jsonByte)
+	} else {
+		var err error
+		if cfg, err = config.ReadConfigFileToJson(); err != nil {
+			return fail("读取配置失败: %v", err)
+		}
+	}
+
+	users := config.GetUsers(cfg)
+	if len(users) == 0 {
+		errs = append(errs, "没有配置用户")
+	}
+	usernames := map[string]bool{}
+	for i, user := range users {
+		prefix := fmt.Sprintf("用户[%d] %s: ", i, user.Username)
+		if user.Username == "" {
+			errs = append(errs, prefix+"用户名不能为空")
+		} else if usernames[user.Username] {
+			errs = append(errs, prefix+"用户名重复")
+		}
+		usernames[user.Username] = true
+		// 启用单点登录时可以不设置密码
+		if user.Password == "" && !cfg.Get("oidc.enable").Bool() && !cfg.Get("auth_header.enable").Bool() {
+			errs = append(errs, prefix+"密码不能为空")
+		}
+		if !config.IsValidRole(user.Role) {
+			errs = append(errs, prefix+"角色无效: "+user.Role)
+		}
+	}
+	if len(users) > 0 && config.CountAdmins(users) == 0 {
+		errs = append(errs, "至少需要一个可用的管理员")
+	}
+	for _, key := range []string{"cookie_expire_days", "log_clean_days", "audit_retention_days",
+		"login_limit.max_failures", "login_limit.ip_max_failures", "login_limit.lock_minutes", "login_limit.max_delay_seconds"} {
+		if v := cfg.Get(key); v.Exists() && v.Int() <= 0 {
+			errs = append(errs, key+" 必须大于0")
+		}
+	}
+
+	if _, err := serve.ParseIPAccess(cfg.Get("ip_access")); err != nil {
+		errs = append(errs, "ip_access: "+err.Error())
+	}
+	for _, item := range cfg.Get("trusted_proxies").Array() {
+		if _, _, err := net.ParseCIDR(item.String()); err != nil && net.ParseIP(item.String()) == nil {
+			errs = append(errs, "trusted_proxies 无效: "+item.String())
+		}
+	}
+	if (cfg.Get("tls_cert").String() == "") != (cfg.Get("tls_key").String() == "") {
+		errs = append(errs, "tls_cert 和 tls_key 需要同时设置")
+	}
+	if _, err := serve.ParseListen(cfg, ""); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if m := cfg.Get("metrics"); m.Get("enable").Bool() {
+		if m.Get("token").String() == "" && len(m.Get("allow").Array()) == 0 {
+			errs = append(errs, "启用 metrics 时需要设置 token 或 allow")
+		}
+		for _, item := range m.Get("allow").Array() {
+			if _, _, err := net.ParseCIDR(item.String()); err != nil && net.ParseIP(item.String()) == nil {
+				errs = append(errs, "metrics.allow 无效: "+item.String())
+			}
+		}
+	}
+	if o := cfg.Get("oidc"); o.Get("enable").Bool() && o.Get("link_existing").Bool() && o.Get("username_claim").String() == "" {
+		warns = append(warns, "oidc.link_existing 已开启但未设置 username_claim,将按 preferred_username 对应已有的本地用户")
+	}
+	if dir := cfg.Get("ui_dir").String(); dir != "" {
+		if !filepath.IsAbs(dir) {
+			dir = pathutil.GetDataPath(dir)
+		}
+		if !pathutil.IsFileExist(dir) {
+			warns = append(warns, "ui_dir 不存在,将使用内置前端: "+dir)
+		}
+	}
+	if _, err := serve.NormalizeBasePath(cfg.Get("base_path").String()); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	names := map[string]bool{}
+	for i, task := range cfg.Get("task").Array() {
+		name := task.Get("name").String()
+		prefix := fmt.Sprintf("任务[%d] %s: ", i, name)
+		if name == "" {
+			errs = append(errs, prefix+"名称不能为空")
+		} else if names[name] {
+			errs = append(errs, prefix+"名称重复")
+		}
+		names[name] = true
+
+		if task.Get("exec").String() == "" {
+			errs = append(errs, prefix+"执行命令不能为空")
+		}
+		times := task.Get("times").Array()
+		if len(times) == 0 {
+			errs = append(errs, prefix+"定时表达式不能为空")
+		}
+		for _, t := range times {
+			if _, err := xuanwu.Parser.Parse(t.String()); err != nil {
+				errs = append(errs, prefix+fmt.Sprintf("定时表达式错误[%s]: %v", t.String(), err))
+			}
+		}
+		if dir := xuanwu.HandleWorkDir(task.Get("workdir").String()); !pathutil.IsFileExist(dir) {
+			warns = append(warns, prefix+"工作目录不存在: "+dir)
+		}
+	}
+
+	for _, w := range warns {
+		fmt.Println("警告: " + w)
+	}
+	for _, e := range errs {
+		fmt.Println("错误: " + e)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	fmt.Printf("配置有效，共 %d 个任务\n", len(names))
+	return 0
+}
@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+	"xuanwu/lib/pathutil"
+)
+
+// 日志查看
+func cmdLogs(args []string) int {
+	if len(args) == 0 || args[0] != "tail" {
+		fmt.Print(usageText)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("logs tail", flag.ExitOnError)
+	lines := fs.Int("n", 20, "显示最后几行")
+	follow := fs.Bool("f", false, "持续输出新增内容")
+	fs.Parse(args[1:])
+	if fs.NArg() != 1 {
+		return fail("请指定任务名称")
+	}
+
+	logPath := pathutil.GetLogPath(fs.Arg(0) + ".log")
+	f, err := os.Open(logPath)
+	if err != nil {
+		return fail("打开日志失败: %v", err)
+	}
+	defer f.Close()
+
+	offset, err := printTail(f, *lines)
+	if err != nil {
+		return fail("读取日志失败: %v", err)
+	}
+	if !*follow {
+		return 0
+	}
+
+	for {
+		time.Sleep(500 * time.Millisecond)
+		info, err := f.Stat()
+		if err != nil {
+			return fail("读取日志失败: %v", err)
+		}
+		// 日志被清理后文件变小,从头开始读
+		if info.Size() < offset {
+			offset = 0
+		}
+		if info.Size() == offset {
+			continue
+		}
+		f.Seek(offset, io.SeekStart)
+		n, _ := io.Copy(os.Stdout, f)
+		offset += n
+	}
+}
+
+// 输出最后n行,返回文件末尾位置
+func printTail(f *os.File, n int) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+
+	// 从文件末尾按块向前读取,直到找到足够的换行
+	const chunk = 32 * 1024
+	var buf []byte
+	pos := size
+	for pos > 0 && bytes.Count(buf, []byte("\n")) <= n {
+		readSize := int64(chunk)
+		if pos < readSize {
+			readSize = pos
+		}
+		pos -= readSize
+		block := make([]byte, readSize)
+		if _, err := f.ReadAt(block, pos); err != nil && err != io.EOF {
+			return 0, err
+		}
+		buf = append(block, buf...)
+	}
+
+	buf = bytes.TrimRight(buf, "\n")
+	if idx := lastNLines(buf, n); idx > 0 {
+		buf = buf[idx:]
+	}
+	if len(buf) > 0 {
+		os.Stdout.Write(append(buf, '\n'))
+	}
+	return size, nil
+}
+
+// 最后n行的起始位置
+func lastNLines(buf []byte, n int) int {
+	count := 0
+	for i := len(buf) - 1; i >= 0; i-- {
+		if buf[i] == '\n' {
+			count++
+			if count == n {
+				return i + 1
+			}
+		}
+	}
+	return 0
+}
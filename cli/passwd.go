@@ -0,0 +1,143 @@
+package cli
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"xuanwu/config"
+	"xuanwu/lib"
+
+	"github.com/tidwall/gjson"
+	"golang.org/x/term"
+)
+
+// 重置登录密码
+func cmdPasswd(args []string) int {
+	fs := flag.NewFlagSet("passwd", flag.ExitOnError)
+	username := fs.String("u", "", "用户名,默认为第一个管理员")
+	password := fs.String("p", "", "新密码")
+	resetTOTP := fs.Bool("reset-totp", false, "同时关闭两步验证")
+	fs.Parse(args)
+
+	if *password == "" {
+		pass, err := readPassword()
+		if err != nil {
+			return fail("读取密码失败: %v", err)
+		}
+		*password = pass
+	}
+	if *password == "" {
+		return fail("密码不能为空")
+	}
+
+	// 网页登录传来的是SHA256值,保存其argon2id哈希
+	hash, err := lib.HashPassword(lib.SHA256(*password))
+	if err != nil {
+		return fail("密码加密失败: %v", err)
+	}
+
+	var target string
+	err = config.UpdateConfig(cliUser, func(cfg gjson.Result) ([]byte, error) {
+		users := config.GetUsers(cfg)
+		index := -1
+		for i, user := range users {
+			if (*username == "" && user.Role == config.ROLE_ADMIN) || (*username != "" && user.Username == *username) {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return nil, fmt.Errorf("用户不存在: %s", *username)
+		}
+
+		users[index].Password = hash
+		// 忘记密码时通常也需要解除禁用
+		users[index].Disabled = false
+		if *resetTOTP {
+			users[index].TOTPSecret = ""
+			users[index].RecoveryCodes = nil
+		}
+		target = users[index].Username
+		jsonStr, err := config.SetUsers(cfg.Raw, users)
+		return []byte(jsonStr), err
+	})
+	if err != nil {
+		return fail("%v", err)
+	}
+	fmt.Printf("用户 %s 的密码已重置\n", target)
+
+	// 密码可能已泄露,原有的登录会话和访问令牌一并失效
+	if err := revokeUserCredentials(target); err != nil {
+		return fail("注销会话和访问令牌失败: %v", err)
+	}
+	notifyServer()
+	return 0
+}
+
+// 删除用户的全部会话和访问令牌
+func revokeUserCredentials(user string) error {
+	store := config.GetStore()
+	list, err := store.ListSessions()
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for _, sess := range list {
+		if sess.User == user {
+			ids = append(ids, sess.ID)
+		}
+	}
+	if len(ids) > 0 {
+		if err := store.DeleteSessions(ids...); err != nil {
+			return err
+		}
+	}
+
+	tokens, err := store.ListApiTokens()
+	if err != nil {
+		return err
+	}
+	revoked := 0
+	for _, token := range tokens {
+		if token.User != user {
+			continue
+		}
+		if err := store.DeleteApiToken(token.ID); err != nil {
+			return err
+		}
+		revoked++
+	}
+	fmt.Printf("已注销 %d 个会话和 %d 个访问令牌\n", len(ids), revoked)
+	return nil
+}
+
+// 从终端读取两次密码,非终端时读取一行
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	fmt.Print("新密码: ")
+	first, err := term.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		return "", err
+	}
+	fmt.Print("再次输入: ")
+	second, err := term.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		return "", err
+	}
+	if string(first) != string(second) {
+		return "", fmt.Errorf("两次输入的密码不一致")
+	}
+	return string(first), nil
+}
@@ -0,0 +1,179 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"xuanwu/config"
+	xwlog "xuanwu/log"
+	"xuanwu/xuanwu"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// 可重复的字符串参数
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// 任务管理
+func cmdTask(args []string) int {
+	if len(args) == 0 {
+		fmt.Print(usageText)
+		return 2
+	}
+	switch args[0] {
+	case "list":
+		return taskList()
+	case "add":
+		return taskAdd(args[1:])
+	case "enable":
+		return taskSetEnable(args[1:], true)
+	case "disable":
+		return taskSetEnable(args[1:], false)
+	case "run":
+		return taskRun(args[1:])
+	}
+	return fail("未知的task命令: %s", args[0])
+}
+
+// 按名称查找任务下标,不存在时返回-1
+func findTask(cfg gjson.Result, name string) (int, gjson.Result) {
+	for i, task := range cfg.Get("task").Array() {
+		if task.Get("name").String() == name {
+			return i, task
+		}
+	}
+	return -1, gjson.Result{}
+}
+
+func taskList() int {
+	cfg, err := config.ReadConfigFileToJson()
+	if err != nil {
+		return fail("读取配置文件失败: %v", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "名称\t启用\t定时\t工作目录\t命令")
+	for _, task := range cfg.Get("task").Array() {
+		var times []string
+		for _, t := range task.Get("times").Array() {
+			times = append(times, t.String())
+		}
+		enable := "否"
+		if task.Get("enable").Bool() {
+			enable = "是"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", task.Get("name").String(), enable,
+			strings.Join(times, " | "), task.Get("workdir").String(), task.Get("exec").String())
+	}
+	w.Flush()
+	return 0
+}
+
+func taskAdd(args []string) int {
+	fs := flag.NewFlagSet("task add", flag.ExitOnError)
+	name := fs.String("name", "", "任务名称")
+	workdir := fs.String("workdir", "", "工作目录")
+	exec := fs.String("exec", "", "执行命令")
+	disable := fs.Bool("disable", false, "添加后不启用")
+	var times stringList
+	fs.Var(&times, "times", "定时表达式,可重复指定")
+	fs.Parse(args)
+
+	if *name == "" || *exec == "" || len(times) == 0 {
+		return fail("-name、-times、-exec 不能为空")
+	}
+	for _, spec := range times {
+		if _, err := xuanwu.Parser.Parse(spec); err != nil {
+			return fail("定时表达式错误[%s]: %v", spec, err)
+		}
+	}
+
+	task := map[string]interface{}{
+		"name":    *name,
+		"times":   []string(times),
+		"workdir": *workdir,
+		"exec":    *exec,
+		"enable":  !*disable,
+	}
+	// 在保存锁内读取和写入,不会覆盖运行中的服务同时做的修改
+	err := config.UpdateConfig(cliUser, func(cfg gjson.Result) ([]byte, error) {
+		if i, _ := findTask(cfg, *name); i >= 0 {
+			return nil, fmt.Errorf("任务已存在: %s", *name)
+		}
+		jsonStr, err := sjson.Set(cfg.Raw, "task.-1", task)
+		return []byte(jsonStr), err
+	})
+	if err != nil {
+		return fail("%v", err)
+	}
+	fmt.Println("添加成功")
+	notifyServer()
+	return 0
+}
+
+func taskSetEnable(args []string, enable bool) int {
+	if len(args) != 1 {
+		return fail("请指定任务名称")
+	}
+	err := config.UpdateConfig(cliUser, func(cfg gjson.Result) ([]byte, error) {
+		i, _ := findTask(cfg, args[0])
+		if i < 0 {
+			return nil, fmt.Errorf("任务不存在: %s", args[0])
+		}
+		jsonStr, err := sjson.Set(cfg.Raw, fmt.Sprintf("task.%d.enable", i), enable)
+		return []byte(jsonStr), err
+	})
+	if err != nil {
+		return fail("%v", err)
+	}
+	if enable {
+		fmt.Println("启用成功")
+	} else {
+		fmt.Println("禁用成功")
+	}
+	notifyServer()
+	return 0
+}
+
+// 在当前进程中执行任务,输出同时写入任务日志
+func taskRun(args []string) int {
+	if len(args) != 1 {
+		return fail("请指定任务名称")
+	}
+	cfg, err := config.ReadConfigFileToJson()
+	if err != nil {
+		return fail("读取配置文件失败: %v", err)
+	}
+	i, task := findTask(cfg, args[0])
+	if i < 0 {
+		return fail("任务不存在: %s", args[0])
+	}
+
+	_, file := xwlog.LogInitWithConfig(args[0]+".log", &xwlog.LogConfig{TaskLogFormat: true})
+	var out io.Writer = os.Stdout
+	if file != nil {
+		defer file.Close()
+		out = io.MultiWriter(file, os.Stdout)
+	}
+	logger := log.New(out, "", 0)
+
+	err = xuanwu.RunTask(args[0], task.Get("exec").String(), task.Get("workdir").String(), logger, xuanwu.TRIGGER_CLI)
+	if err != nil {
+		return fail("任务执行失败: %v", err)
+	}
+	return 0
+}
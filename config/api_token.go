@@ -0,0 +1,143 @@
+package config
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+const API_TOKEN_FILE = "api_tokens.json"
+
+// ApiToken 个人访问令牌,只保存令牌的SHA256值
+type ApiToken struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	User     string    `json:"user"` // 创建者,权限不超过创建者的角色
+	Hash     string    `json:"hash,omitempty"`
+	Scopes   []string  `json:"scopes"`
+	IPs      []string  `json:"ips,omitempty"` // 允许访问的IP或CIDR,为空时不限制
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires,omitzero"` // 零值表示永不过期
+	LastUsed time.Time `json:"last_used,omitzero"`
+	LastIP   string    `json:"last_ip,omitempty"`
+}
+
+// IsExpired 是否已过期
+func (t ApiToken) IsExpired(now time.Time) bool {
+	return !t.Expires.IsZero() && !t.Expires.After(now)
+}
+
+// 按创建时间倒序
+func sortApiTokens(list []ApiToken) {
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.After(list[j].Created)
+	})
+}
+
+/* JSON存储 */
+
+func (s *JSONStore) SaveApiToken(token ApiToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list, err := readJSONFile[ApiToken](API_TOKEN_FILE)
+	if err != nil {
+		return err
+	}
+	replaced := false
+	for i := range list {
+		if list[i].ID == token.ID {
+			list[i] = token
+			replaced = true
+		}
+	}
+	if !replaced {
+		list = append(list, token)
+	}
+	return writeJSONFile(API_TOKEN_FILE, list)
+}
+
+func (s *JSONStore) ListApiTokens() ([]ApiToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list, err := readJSONFile[ApiToken](API_TOKEN_FILE)
+	if err != nil {
+		return nil, err
+	}
+	sortApiTokens(list)
+	return list, nil
+}
+
+func (s *JSONStore) DeleteApiToken(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list, err := readJSONFile[ApiToken](API_TOKEN_FILE)
+	if err != nil {
+		return err
+	}
+	kept := list[:0]
+	for _, token := range list {
+		if token.ID != id {
+			kept = append(kept, token)
+		}
+	}
+	return writeJSONFile(API_TOKEN_FILE, kept)
+}
+
+/* SQLite存储 */
+
+func (s *SQLiteStore) SaveApiToken(token ApiToken) error {
+	return saveApiToken(s.db, token)
+}
+
+func saveApiToken(ex sqlExecer, token ApiToken) error {
+	scopes, _ := json.Marshal(token.Scopes)
+	ips, _ := json.Marshal(token.IPs)
+	var expires, lastUsed int64
+	if !token.Expires.IsZero() {
+		expires = token.Expires.UnixMilli()
+	}
+	if !token.LastUsed.IsZero() {
+		lastUsed = token.LastUsed.UnixMilli()
+	}
+	_, err := ex.Exec(`INSERT OR REPLACE INTO api_tokens(id, name, user, hash, scopes, ips, created, expires, last_used, last_ip)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		token.ID, token.Name, token.User, token.Hash, string(scopes), string(ips),
+		token.Created.UnixMilli(), expires, lastUsed, token.LastIP)
+	return err
+}
+
+func (s *SQLiteStore) ListApiTokens() ([]ApiToken, error) {
+	rows, err := s.db.Query(`SELECT id, name, user, hash, scopes, ips, created, expires, last_used, last_ip
+		FROM api_tokens ORDER BY created DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []ApiToken{}
+	for rows.Next() {
+		var token ApiToken
+		var scopes, ips string
+		var created, expires, lastUsed int64
+		if err := rows.Scan(&token.ID, &token.Name, &token.User, &token.Hash, &scopes, &ips,
+			&created, &expires, &lastUsed, &token.LastIP); err != nil {
+			return nil, err
+		}
+		json.Unmarshal([]byte(scopes), &token.Scopes)
+		json.Unmarshal([]byte(ips), &token.IPs)
+		token.Created = time.UnixMilli(created)
+		if expires > 0 {
+			token.Expires = time.UnixMilli(expires)
+		}
+		if lastUsed > 0 {
+			token.LastUsed = time.UnixMilli(lastUsed)
+		}
+		list = append(list, token)
+	}
+	return list, rows.Err()
+}
+
+func (s *SQLiteStore) DeleteApiToken(id string) error {
+	_, err := s.db.Exec("DELETE FROM api_tokens WHERE id = ?", id)
+	return err
+}
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"runtime"
+	"runtime/debug"
 	"xuanwu/lib/pathutil"
 
 	"github.com/tidwall/gjson"
@@ -15,39 +17,84 @@ import (
 var (
 	IsWindows = runtime.GOOS == "windows"
 	Version   = "0.0.0"
+	Commit    = "" // 编译时通过 -ldflags "-X xuanwu/config.Commit=..." 设置,为空时使用go记录的版本控制信息
+	BuildTime = "" // 编译时间,同样通过 ldflags 设置
 )
 
+// 编译信息
+type Build struct {
+	Version    string `json:"version"`
+	Commit     string `json:"commit,omitempty"`
+	CommitTime string `json:"commit_time,omitempty"`
+	Modified   bool   `json:"modified,omitempty"` // 编译时有未提交的修改
+	BuildTime  string `json:"build_time,omitempty"`
+	GoVersion  string `json:"go_version"`
+	Platform   string `json:"platform"`
+}
+
+// BuildInfo 获取编译信息,未通过 ldflags 设置提交时读取go编译时记录的git信息
+func BuildInfo() Build {
+	b := Build{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if b.Commit == "" {
+				b.Commit = s.Value
+			}
+		case "vcs.time":
+			b.CommitTime = s.Value
+		case "vcs.modified":
+			b.Modified = s.Value == "true"
+		}
+	}
+	return b
+}
+
 // 将config文件读取到json字符串
 func ReadConfigFileToJson() (gjson.Result, error) {
-	configPath := pathutil.GetConfigPath()
+	return GetStore().Load()
+}
+
+// 读取config文件,不存在时创建默认配置
+// 文件存在但无法读取或格式错误时返回错误,不能用默认配置(admin/admin)代替
+func readConfigFile(configPath string) (gjson.Result, error) {
 	jsonByte, err := os.ReadFile(configPath)
+	if err != nil && !os.IsNotExist(err) {
+		return gjson.Result{}, fmt.Errorf("配置文件读取失败: %v", err)
+	}
 	if err != nil {
-		fmt.Println("配置文件读取失败")
+		fmt.Println("配置文件不存在")
 		/* 配置文件不存在,创建json文件 */
-		str := fmt.Sprintf(`{
-			"name": "xuanwu",
-			"username":"admin",
-			"password":"8c6976e5b5410415bde908bd4dee15dfb167a9c873fc4bb8a81f6f2ab448a918",
-			"cookie_expire_days": 30,
-			"log_clean_days": 7,
-			"task": []
-		  }`)
-		err := WriteConfigFile(configPath, []byte(str))
+		err := WriteConfigFile(configPath, []byte(defaultConfig))
 		if err != nil {
 			log.Println("配置文件创建失败")
 			return gjson.Parse(""), err
 		}
 		log.Println("配置文件创建成功")
-		return gjson.Parse(str), nil
+		return gjson.Parse(defaultConfig), nil
 	}
 
-	return gjson.Parse(string(jsonByte)), nil
+	if !gjson.ValidBytes(jsonByte) {
+		return gjson.Result{}, fmt.Errorf("配置文件 %s 不是有效的JSON", configPath)
+	}
+	return gjson.ParseBytes(jsonByte), nil
 }
 
 // 写入json到config文件
+// 先写入临时文件再重命名,读取的一方不会看到写了一半的配置
 func WriteConfigFile(filePath string, data []byte) error {
-	if err := pathutil.EnsureFile(filePath); err != nil {
-		fmt.Println("config文件创建失败")
+	if err := pathutil.EnsureDir(filepath.Dir(filePath)); err != nil {
+		fmt.Println("config目录创建失败")
 		return err
 	}
 
@@ -58,18 +105,14 @@ func WriteConfigFile(filePath string, data []byte) error {
 		return err
 	}
 
-	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_TRUNC, 0644)
-	if err != nil {
-		fmt.Println("config文件打开失败")
-		return err
+	// 保持原文件的权限
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(filePath); err == nil {
+		perm = info.Mode().Perm()
 	}
-	defer f.Close()
-
-	_, err = f.Write(prettyJSON.Bytes())
-	if err != nil {
+	if err := writeFileAtomic(filePath, prettyJSON.Bytes(), perm); err != nil {
 		fmt.Println("config文件写入失败")
 		return err
 	}
-
 	return nil
 }
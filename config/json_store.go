@@ -0,0 +1,374 @@
+package config
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"xuanwu/lib/pathutil"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	RUNS_FILE     = "runs.jsonl"
+	AUDIT_FILE    = "audit.jsonl"
+	REVISION_FILE = "revisions.jsonl"
+)
+
+// JSONStore 默认的config.json存储
+// 运行记录、审计记录和配置修订以每行一条json的方式追加到数据目录下
+type JSONStore struct {
+	path    string
+	mu      sync.Mutex
+	cache   gjson.Result
+	modTime time.Time
+	size    int64
+	lastID  map[string]int64
+	closed  bool // 关闭后不再读写config.json,避免恢复备份期间读到缺失的文件而生成默认配置
+}
+
+var errStoreClosed = errors.New("存储已关闭")
+
+func NewJSONStore(path string) *JSONStore {
+	return &JSONStore{path: path, lastID: map[string]int64{}}
+}
+
+func (s *JSONStore) Name() string {
+	return STORAGE_JSON
+}
+
+// Load 读取配置,文件未变化时直接返回缓存
+func (s *JSONStore) Load() (gjson.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return gjson.Result{}, errStoreClosed
+	}
+
+	// 读取前记录文件状态,读取期间文件被替换时下次会重新读取
+	info, statErr := os.Stat(s.path)
+	if statErr == nil && s.cache.Exists() &&
+		info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return s.cache, nil
+	}
+
+	cfg, err := readConfigFile(s.path)
+	if err != nil {
+		s.cache = gjson.Result{}
+		return cfg, err
+	}
+	if statErr != nil {
+		// 刚创建的默认配置
+		s.setCache(cfg)
+		return cfg, nil
+	}
+	s.cache = cfg
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+	return cfg, nil
+}
+
+func (s *JSONStore) Save(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errStoreClosed
+	}
+
+	if err := WriteConfigFile(s.path, data); err != nil {
+		s.cache = gjson.Result{}
+		return err
+	}
+	s.setCache(gjson.ParseBytes(data))
+	return nil
+}
+
+// 记录缓存对应的文件状态
+func (s *JSONStore) setCache(cfg gjson.Result) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		s.cache = gjson.Result{}
+		return
+	}
+	s.cache = cfg
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+}
+
+func (s *JSONStore) AddRun(rec RunRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec.ID = s.nextID(RUNS_FILE)
+	return appendJSONLine(pathutil.GetDataPath(RUNS_FILE), rec)
+}
+
+func (s *JSONStore) ListRuns(q RunQuery) ([]RunRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []RunRecord
+	err := readJSONLines(pathutil.GetDataPath(RUNS_FILE), func(line []byte) {
+		var rec RunRecord
+		if json.Unmarshal(line, &rec) != nil {
+			return
+		}
+		if q.Name != "" && rec.Name != q.Name {
+			return
+		}
+		list = append(list, rec)
+	})
+	return newestFirst(list, q.Limit), err
+}
+
+func (s *JSONStore) AddAudit(entry AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry.ID = s.nextID(AUDIT_FILE)
+	return appendJSONLine(pathutil.GetDataPath(AUDIT_FILE), entry)
+}
+
+func (s *JSONStore) ListAudit(q AuditQuery) ([]AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []AuditEntry
+	err := readJSONLines(pathutil.GetDataPath(AUDIT_FILE), func(line []byte) {
+		var entry AuditEntry
+		if json.Unmarshal(line, &entry) != nil {
+			return
+		}
+		if q.match(entry) {
+			list = append(list, entry)
+		}
+	})
+	return newestFirst(list, q.Limit), err
+}
+
+func (s *JSONStore) AddRevision(rev Revision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rev.ID = s.nextID(REVISION_FILE)
+	return appendJSONLine(pathutil.GetDataPath(REVISION_FILE), rev)
+}
+
+func (s *JSONStore) ListRevisions(limit int) ([]Revision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []Revision
+	err := readJSONLines(pathutil.GetDataPath(REVISION_FILE), func(line []byte) {
+		var rev Revision
+		if json.Unmarshal(line, &rev) == nil {
+			rev.Data = nil
+			list = append(list, rev)
+		}
+	})
+	return newestFirst(list, limit), err
+}
+
+func (s *JSONStore) GetRevision(id int64) (Revision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var found *Revision
+	err := readJSONLines(pathutil.GetDataPath(REVISION_FILE), func(line []byte) {
+		if gjson.GetBytes(line, "id").Int() != id {
+			return
+		}
+		var rev Revision
+		if json.Unmarshal(line, &rev) == nil {
+			found = &rev
+		}
+	})
+	if err != nil {
+		return Revision{}, err
+	}
+	if found == nil {
+		return Revision{}, ErrRevisionNotFound
+	}
+	return *found, nil
+}
+
+func (s *JSONStore) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.cache = gjson.Result{}
+	s.mu.Unlock()
+	return nil
+}
+
+// 获取下一个记录ID
+func (s *JSONStore) nextID(name string) int64 {
+	s.loadLastID(name)
+	s.lastID[name]++
+	return s.lastID[name]
+}
+
+// 首次使用时从文件中读取最大的记录ID
+func (s *JSONStore) loadLastID(name string) {
+	if _, ok := s.lastID[name]; ok {
+		return
+	}
+	s.lastID[name] = 0
+	readJSONLines(pathutil.GetDataPath(name), func(line []byte) {
+		if id := gjson.GetBytes(line, "id").Int(); id > s.lastID[name] {
+			s.lastID[name] = id
+		}
+	})
+}
+
+// PruneRuns 删除指定时间之前的运行记录
+func (s *JSONStore) PruneRuns(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pruneLines(RUNS_FILE, "start", before)
+}
+
+// PruneAudit 删除指定时间之前的审计记录
+func (s *JSONStore) PruneAudit(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pruneLines(AUDIT_FILE, "time", before)
+}
+
+// 重写文件,只保留时间字段不早于 before 的行
+func (s *JSONStore) pruneLines(name, field string, before time.Time) error {
+	// 删除后新记录的ID仍需要继续递增
+	s.loadLastID(name)
+
+	path := pathutil.GetDataPath(name)
+	var kept []byte
+	removed := 0
+	err := readJSONLines(path, func(line []byte) {
+		if t := gjson.GetBytes(line, field).Time(); !t.IsZero() && t.Before(before) {
+			removed++
+			return
+		}
+		kept = append(kept, line...)
+		kept = append(kept, '\n')
+	})
+	if err != nil || removed == 0 {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, kept, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// 是否符合审计查询条件
+func (q AuditQuery) match(entry AuditEntry) bool {
+	if q.User != "" && entry.User != q.User {
+		return false
+	}
+	if q.Action != "" && entry.Action != q.Action {
+		return false
+	}
+	if q.Target != "" && entry.Target != q.Target {
+		return false
+	}
+	if !q.Since.IsZero() && entry.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && entry.Time.After(q.Until) {
+		return false
+	}
+	return true
+}
+
+// 追加一行json到文件
+func appendJSONLine(path string, v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := pathutil.EnsureDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// 逐行读取json文件,文件不存在时不报错
+func readJSONLines(path string, fn func(line []byte)) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		if line := scanner.Bytes(); len(line) > 0 {
+			fn(line)
+		}
+	}
+	return scanner.Err()
+}
+
+// 读取保存为json数组的文件,文件不存在时返回空列表
+func readJSONFile[T any](name string) ([]T, error) {
+	list := []T{}
+	data, err := os.ReadFile(pathutil.GetDataPath(name))
+	if os.IsNotExist(err) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// 整体重写json数组文件,内容可能包含IP等信息,只允许当前用户读写
+func writeJSONFile[T any](name string, list []T) error {
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	if err := pathutil.EnsureDir(pathutil.GetDataPath("")); err != nil {
+		return err
+	}
+	path := pathutil.GetDataPath(name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// 倒序并截取前limit条
+func newestFirst[T any](list []T, limit int) []T {
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list
+}
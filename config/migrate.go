@@ -0,0 +1,165 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"xuanwu/lib/pathutil"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// 迁移后config.json只保留的引导配置
+const bootstrapConfig = "{\n    \"storage\": \"sqlite\"\n}"
+
+// MigrateJSONToSQLite 将config.json及运行记录、审计记录一次性迁移到SQLite
+// 迁移后原config.json备份为config.json.bak(不含密码等敏感字段),并改写为只包含storage的引导配置
+// 数据库在一个事务中写入,提交后再通过一次重命名替换config.json,
+// 中途失败时config.json保持不变,数据库中也不会留下部分数据
+func MigrateJSONToSQLite(s *SQLiteStore) error {
+	configPath := pathutil.GetConfigPath()
+	jsonByte, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("读取config.json失败: %v", err)
+	}
+	data, _ := sjson.Delete(string(jsonByte), "storage")
+
+	if err := writeFileAtomic(configPath+".bak", StripSecrets(jsonByte), 0600); err != nil {
+		return fmt.Errorf("备份config.json失败: %v", err)
+	}
+	// 引导配置先写入临时文件,数据库提交后再替换
+	tmp := configPath + ".tmp"
+	if err := os.WriteFile(tmp, []byte(bootstrapConfig), 0644); err != nil {
+		return fmt.Errorf("改写config.json失败: %v", err)
+	}
+	defer os.Remove(tmp)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := saveConfigTx(tx, []byte(data)); err != nil {
+		return fmt.Errorf("写入配置失败: %v", err)
+	}
+
+	var runCount, auditCount int
+	err = readJSONLines(pathutil.GetDataPath(RUNS_FILE), func(line []byte) {
+		var rec RunRecord
+		if json.Unmarshal(line, &rec) == nil && addRun(tx, rec) == nil {
+			runCount++
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("读取运行记录失败: %v", err)
+	}
+	err = readJSONLines(pathutil.GetDataPath(AUDIT_FILE), func(line []byte) {
+		var entry AuditEntry
+		if json.Unmarshal(line, &entry) == nil && addAudit(tx, entry) == nil {
+			auditCount++
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("读取审计记录失败: %v", err)
+	}
+	err = readJSONLines(pathutil.GetDataPath(REVISION_FILE), func(line []byte) {
+		var rev Revision
+		if json.Unmarshal(line, &rev) == nil {
+			rev.Data = StripSecrets(rev.Data)
+			addRevision(tx, rev)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("读取配置修订失败: %v", err)
+	}
+
+	jsonStore := NewJSONStore(configPath)
+	if sessions, err := jsonStore.ListSessions(); err == nil {
+		for _, sess := range sessions {
+			if err := saveSession(tx, sess); err != nil {
+				return fmt.Errorf("迁移会话失败: %v", err)
+			}
+		}
+	}
+	if tokens, err := jsonStore.ListApiTokens(); err == nil {
+		for _, token := range tokens {
+			if err := saveApiToken(tx, token); err != nil {
+				return fmt.Errorf("迁移API令牌失败: %v", err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("写入数据库失败: %v", err)
+	}
+	s.cache = gjson.Result{}
+	if err := os.Rename(tmp, configPath); err != nil {
+		return fmt.Errorf("改写config.json失败: %v", err)
+	}
+
+	log.Printf("配置已迁移到SQLite，任务%d个，运行记录%d条，审计记录%d条",
+		len(gjson.Get(data, "task").Array()), runCount, auditCount)
+	return nil
+}
+
+// 数据库为空且存在config.json时自动迁移
+// 上次迁移已提交但config.json未能改写时,补做最后的替换
+func migrateIfEmpty(s *SQLiteStore) error {
+	empty, err := s.isEmpty()
+	if err != nil {
+		return err
+	}
+	configPath := pathutil.GetConfigPath()
+	jsonByte, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil
+	}
+	// 只有引导配置时没有可迁移的内容
+	var cfg map[string]interface{}
+	if json.Unmarshal(jsonByte, &cfg) != nil || len(cfg) <= 1 {
+		return nil
+	}
+	if empty {
+		return MigrateJSONToSQLite(s)
+	}
+
+	bak, err := os.ReadFile(configPath + ".bak")
+	if err != nil || string(bak) != string(StripSecrets(jsonByte)) {
+		log.Printf("config.json 中除 storage 外的配置不会生效，当前使用SQLite中的配置")
+		return nil
+	}
+	log.Printf("完成上次未结束的SQLite迁移，改写config.json")
+	return writeFileAtomic(configPath, []byte(bootstrapConfig), 0644)
+}
+
+// 先写入同目录的临时文件再重命名,避免写入中途失败留下不完整的文件
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
@@ -0,0 +1,279 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"xuanwu/lib/flock"
+	"xuanwu/lib/pathutil"
+
+	"github.com/tidwall/gjson"
+)
+
+// Revision 配置修订记录
+type Revision struct {
+	ID   int64           `json:"id"`
+	Time time.Time       `json:"time"`
+	User string          `json:"user"`
+	Diff []DiffOp        `json:"diff"`
+	Data json.RawMessage `json:"data,omitempty"` // 修改后的完整配置,不含密码等敏感字段
+}
+
+// DiffOp 单项配置差异
+type DiffOp struct {
+	Op   string      `json:"op"`   // add/remove/replace
+	Path string      `json:"path"` // 如 task[name].exec
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// 不在差异中显示明文的字段
+var secretKeys = map[string]bool{
+	"password":       true,
+	"totp_secret":    true,
+	"recovery_codes": true,
+}
+
+// 按名称对比的数组及其名称字段,差异路径如 task[name].exec
+var keyedArrays = map[string]string{
+	"task":  "name",
+	"users": "username",
+}
+
+var ErrRevisionNotFound = errors.New("修订记录不存在")
+
+var saveLock sync.Mutex
+
+// 保存完整配置到当前存储后端,并记录修订
+func SaveConfig(data []byte, user string) error {
+	return updateConfig(user, func(gjson.Result) ([]byte, error) {
+		return data, nil
+	}, true)
+}
+
+// UpdateConfig 在保存锁内读取当前配置,由fn生成新配置后保存并记录修订
+// 读取和保存之间不会被其他修改插入,fn返回错误时不保存
+func UpdateConfig(user string, fn func(cfg gjson.Result) ([]byte, error)) error {
+	return updateConfig(user, fn, false)
+}
+
+// overwrite为true时整体覆盖,当前配置读取失败也继续保存
+func updateConfig(user string, fn func(cfg gjson.Result) ([]byte, error), overwrite bool) error {
+	saveLock.Lock()
+	defer saveLock.Unlock()
+
+	// 与命令行等其他进程互斥
+	if lock, err := flock.Acquire(pathutil.GetDataPath(pathutil.CONFIG_LOCK), 5*time.Second); err == nil {
+		defer lock.Release()
+	} else {
+		log.Printf("获取配置文件锁失败: %v", err)
+	}
+
+	s := GetStore()
+	old, err := s.Load()
+	if err != nil && !overwrite {
+		return err
+	}
+	data, err := fn(old)
+	if err != nil {
+		return err
+	}
+	if err := s.Save(data); err != nil {
+		return err
+	}
+
+	diff := DiffJSON(old.Raw, string(data))
+	if len(diff) == 0 {
+		return nil
+	}
+
+	// 首次记录时先保存修改前的配置,便于回滚到最初状态
+	if list, err := s.ListRevisions(1); err == nil && len(list) == 0 && old.Raw != "" {
+		s.AddRevision(Revision{
+			Time: time.Now(),
+			User: "system",
+			Data: json.RawMessage(StripSecrets([]byte(old.Raw))),
+		})
+	}
+
+	rev := Revision{
+		Time: time.Now(),
+		User: user,
+		Diff: diff,
+		Data: json.RawMessage(StripSecrets(compactJSON(data))),
+	}
+	if err := s.AddRevision(rev); err != nil {
+		log.Printf("保存配置修订失败: %v", err)
+	}
+	return nil
+}
+
+// DiffJSON 比较两份配置,任务按名称对比,同名任务按出现顺序区分(见 ItemKeys)
+func DiffJSON(oldRaw, newRaw string) []DiffOp {
+	var oldVal, newVal interface{}
+	if oldRaw != "" {
+		json.Unmarshal([]byte(oldRaw), &oldVal)
+	}
+	if newRaw != "" {
+		json.Unmarshal([]byte(newRaw), &newVal)
+	}
+	var ops []DiffOp
+	diffValue("", oldVal, newVal, &ops)
+	return ops
+}
+
+func diffValue(path string, oldVal, newVal interface{}, ops *[]DiffOp) {
+	oldMap, oldIsMap := oldVal.(map[string]interface{})
+	newMap, newIsMap := newVal.(map[string]interface{})
+	if oldIsMap && newIsMap {
+		keys := map[string]bool{}
+		for k := range oldMap {
+			keys[k] = true
+		}
+		for k := range newMap {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+
+		for _, k := range sorted {
+			childPath := joinPath(path, k)
+			ov, inOld := oldMap[k]
+			nv, inNew := newMap[k]
+			if field, ok := keyedArrays[k]; ok && path == "" {
+				diffKeyed(k, field, ov, nv, ops)
+				continue
+			}
+			switch {
+			case !inOld:
+				*ops = append(*ops, DiffOp{Op: "add", Path: childPath, New: maskSecret(k, nv)})
+			case !inNew:
+				*ops = append(*ops, DiffOp{Op: "remove", Path: childPath, Old: maskSecret(k, ov)})
+			default:
+				diffValue(childPath, ov, nv, ops)
+			}
+		}
+		return
+	}
+
+	if !jsonEqual(oldVal, newVal) {
+		key := path[strings.LastIndex(path, ".")+1:]
+		*ops = append(*ops, DiffOp{Op: "replace", Path: path, Old: maskSecret(key, oldVal), New: maskSecret(key, newVal)})
+	}
+}
+
+// 任务、用户等数组按名称对比
+func diffKeyed(key, field string, oldVal, newVal interface{}, ops *[]DiffOp) {
+	oldItems := itemsByName(oldVal, field)
+	newItems := itemsByName(newVal, field)
+
+	var names []string
+	for name := range oldItems {
+		names = append(names, name)
+	}
+	for name := range newItems {
+		if _, ok := oldItems[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := key + "[" + name + "]"
+		ot, inOld := oldItems[name]
+		nt, inNew := newItems[name]
+		switch {
+		case !inOld:
+			*ops = append(*ops, DiffOp{Op: "add", Path: path, New: maskSecret("", nt)})
+		case !inNew:
+			*ops = append(*ops, DiffOp{Op: "remove", Path: path, Old: maskSecret("", ot)})
+		default:
+			diffValue(path, ot, nt, ops)
+		}
+	}
+}
+
+func itemsByName(val interface{}, field string) map[string]interface{} {
+	items := map[string]interface{}{}
+	list, _ := val.([]interface{})
+	var names []string
+	var values []interface{}
+	for _, t := range list {
+		if m, ok := t.(map[string]interface{}); ok {
+			name, _ := m[field].(string)
+			names = append(names, name)
+			values = append(values, m)
+		}
+	}
+	for i, key := range ItemKeys(names) {
+		items[key] = values[i]
+	}
+	return items
+}
+
+// ItemKeys 数组项在修订对比中的键,同名的项从第二个起依次为 name#2、name#3,
+// SQLite存储允许同名任务,按名称对比时不会合并成一项
+func ItemKeys(names []string) []string {
+	keys := make([]string, len(names))
+	seen := map[string]int{}
+	for i, name := range names {
+		seen[name]++
+		keys[i] = name
+		if n := seen[name]; n > 1 {
+			keys[i] = fmt.Sprintf("%s#%d", name, n)
+		}
+	}
+	return keys
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// 隐藏敏感字段,对象和数组中的敏感字段同样隐藏
+func maskSecret(key string, val interface{}) interface{} {
+	if secretKeys[key] && val != nil {
+		return "******"
+	}
+	switch v := val.(type) {
+	case map[string]interface{}:
+		masked := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			masked[k] = maskSecret(k, item)
+		}
+		return masked
+	case []interface{}:
+		masked := make([]interface{}, len(v))
+		for i, item := range v {
+			masked[i] = maskSecret("", item)
+		}
+		return masked
+	}
+	return val
+}
+
+func jsonEqual(a, b interface{}) bool {
+	ab, _ := json.Marshal(a)
+	bb, _ := json.Marshal(b)
+	return string(ab) == string(bb)
+}
+
+func compactJSON(data []byte) []byte {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return data
+	}
+	b, _ := json.Marshal(v)
+	return b
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"database/sql"
+	"fmt"
+	"xuanwu/lib"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// StripSecrets 删除配置中的密码哈希、两步验证密钥和恢复码
+// 用于配置修订、迁移备份等不需要登录信息的副本,回滚时这些字段保留当前值
+func StripSecrets(data []byte) []byte {
+	if !gjson.ValidBytes(data) {
+		return data
+	}
+	jsonStr := string(data)
+	for key := range secretKeys {
+		jsonStr, _ = sjson.Delete(jsonStr, key)
+	}
+	for i := range gjson.Get(jsonStr, "users").Array() {
+		for key := range secretKeys {
+			jsonStr, _ = sjson.Delete(jsonStr, fmt.Sprintf("users.%d.%s", i, key))
+		}
+	}
+	return []byte(jsonStr)
+}
+
+// RehashLegacyPasswords 将旧版的SHA256密码改为argon2id哈希
+// SHA256值等同于网页登录提交的内容,不能原样出现在备份中,改写后原密码仍然可以登录
+func RehashLegacyPasswords(data []byte) ([]byte, error) {
+	if !gjson.ValidBytes(data) {
+		return data, nil
+	}
+	jsonStr := string(data)
+	rehash := func(path string) error {
+		stored := gjson.Get(jsonStr, path).String()
+		if !lib.IsLegacyHash(stored) {
+			return nil
+		}
+		hash, err := lib.HashPassword(stored)
+		if err != nil {
+			return err
+		}
+		jsonStr, err = sjson.Set(jsonStr, path, hash)
+		return err
+	}
+
+	if err := rehash("password"); err != nil {
+		return data, err
+	}
+	for i := range gjson.Get(jsonStr, "users").Array() {
+		if err := rehash(fmt.Sprintf("users.%d.password", i)); err != nil {
+			return data, err
+		}
+	}
+	return []byte(jsonStr), nil
+}
+
+// ScrubSQLiteFile 清理数据库副本中的敏感信息
+// 配置中的旧版密码改为argon2id哈希,修订记录删除密码等字段,只用于备份时的快照
+func ScrubSQLiteFile(path string) error {
+	s, err := OpenSQLiteStore(path)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// 设置项按顶层键保存,只需处理 password 和 users
+	for _, key := range []string{"password", "users"} {
+		var value string
+		err := tx.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&value)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		doc, _ := sjson.SetRaw("{}", key, value)
+		rehashed, err := RehashLegacyPasswords([]byte(doc))
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE settings SET value = ? WHERE key = ?",
+			gjson.GetBytes(rehashed, key).Raw, key); err != nil {
+			return err
+		}
+	}
+
+	rows, err := tx.Query("SELECT id, data FROM revisions")
+	if err != nil {
+		return err
+	}
+	stripped := map[int64][]byte{}
+	for rows.Next() {
+		var id int64
+		var data string
+		if err := rows.Scan(&id, &data); err != nil {
+			rows.Close()
+			return err
+		}
+		stripped[id] = StripSecrets([]byte(data))
+	}
+	rows.Close()
+	for id, data := range stripped {
+		if _, err := tx.Exec("UPDATE revisions SET data = ? WHERE id = ?", string(data), id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"sort"
+	"time"
+)
+
+const SESSION_FILE = "sessions.json"
+
+// Session 登录会话
+type Session struct {
+	ID        string    `json:"id"` // 与token中的jti一致
+	User      string    `json:"user"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Created   time.Time `json:"created"`
+	LastSeen  time.Time `json:"last_seen"`
+	Expires   time.Time `json:"expires"`
+}
+
+// 会话按最近使用时间倒序
+func sortSessions(list []Session) {
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastSeen.After(list[j].LastSeen)
+	})
+}
+
+/* JSON存储: 所有会话保存在一个文件中,每次修改整体重写 */
+
+func (s *JSONStore) SaveSession(sess Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list, err := readJSONFile[Session](SESSION_FILE)
+	if err != nil {
+		return err
+	}
+	replaced := false
+	for i := range list {
+		if list[i].ID == sess.ID {
+			list[i] = sess
+			replaced = true
+		}
+	}
+	if !replaced {
+		list = append(list, sess)
+	}
+	return writeJSONFile(SESSION_FILE, list)
+}
+
+func (s *JSONStore) ListSessions() ([]Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list, err := readJSONFile[Session](SESSION_FILE)
+	if err != nil {
+		return nil, err
+	}
+	sortSessions(list)
+	return list, nil
+}
+
+func (s *JSONStore) DeleteSessions(ids ...string) error {
+	remove := map[string]bool{}
+	for _, id := range ids {
+		remove[id] = true
+	}
+	return s.filterSessions(func(sess Session) bool { return !remove[sess.ID] })
+}
+
+func (s *JSONStore) DeleteExpiredSessions(now time.Time) error {
+	return s.filterSessions(func(sess Session) bool { return sess.Expires.After(now) })
+}
+
+// 只保留keep返回true的会话,没有变化时不写文件
+func (s *JSONStore) filterSessions(keep func(Session) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list, err := readJSONFile[Session](SESSION_FILE)
+	if err != nil {
+		return err
+	}
+	kept := list[:0]
+	for _, sess := range list {
+		if keep(sess) {
+			kept = append(kept, sess)
+		}
+	}
+	if len(kept) == len(list) {
+		return nil
+	}
+	return writeJSONFile(SESSION_FILE, kept)
+}
+
+/* SQLite存储 */
+
+func (s *SQLiteStore) SaveSession(sess Session) error {
+	return saveSession(s.db, sess)
+}
+
+func saveSession(ex sqlExecer, sess Session) error {
+	_, err := ex.Exec(`INSERT OR REPLACE INTO sessions(id, user, ip, user_agent, created, last_seen, expires)
+		VALUES(?, ?, ?, ?, ?, ?, ?)`,
+		sess.ID, sess.User, sess.IP, sess.UserAgent,
+		sess.Created.UnixMilli(), sess.LastSeen.UnixMilli(), sess.Expires.UnixMilli())
+	return err
+}
+
+func (s *SQLiteStore) ListSessions() ([]Session, error) {
+	rows, err := s.db.Query(`SELECT id, user, ip, user_agent, created, last_seen, expires
+		FROM sessions ORDER BY last_seen DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Session{}
+	for rows.Next() {
+		var sess Session
+		var created, lastSeen, expires int64
+		if err := rows.Scan(&sess.ID, &sess.User, &sess.IP, &sess.UserAgent,
+			&created, &lastSeen, &expires); err != nil {
+			return nil, err
+		}
+		sess.Created = time.UnixMilli(created)
+		sess.LastSeen = time.UnixMilli(lastSeen)
+		sess.Expires = time.UnixMilli(expires)
+		list = append(list, sess)
+	}
+	return list, rows.Err()
+}
+
+func (s *SQLiteStore) DeleteSessions(ids ...string) error {
+	for _, id := range ids {
+		if _, err := s.db.Exec("DELETE FROM sessions WHERE id = ?", id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStore) DeleteExpiredSessions(now time.Time) error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE expires <= ?", now.UnixMilli())
+	return err
+}
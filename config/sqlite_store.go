@@ -0,0 +1,435 @@
+package config
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"xuanwu/lib/pathutil"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	_ "modernc.org/sqlite"
+)
+
+const SQLITE_FILE = "xuanwu.db"
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS settings (
+	key   TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS tasks (
+	position INTEGER PRIMARY KEY,
+	name     TEXT NOT NULL,
+	data     TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS runs (
+	id       INTEGER PRIMARY KEY AUTOINCREMENT,
+	name     TEXT NOT NULL,
+	exec     TEXT NOT NULL,
+	trigger_by TEXT NOT NULL,
+	started_at INTEGER NOT NULL,
+	ended_at   INTEGER NOT NULL,
+	duration INTEGER NOT NULL,
+	success  INTEGER NOT NULL,
+	error    TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS runs_name ON runs(name, id);
+CREATE TABLE IF NOT EXISTS audit (
+	id      INTEGER PRIMARY KEY AUTOINCREMENT,
+	time    INTEGER NOT NULL,
+	user    TEXT NOT NULL,
+	ip      TEXT NOT NULL,
+	action  TEXT NOT NULL,
+	target  TEXT NOT NULL,
+	outcome TEXT NOT NULL,
+	detail  TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS audit_time ON audit(time);
+CREATE TABLE IF NOT EXISTS revisions (
+	id   INTEGER PRIMARY KEY AUTOINCREMENT,
+	time INTEGER NOT NULL,
+	user TEXT NOT NULL,
+	diff TEXT NOT NULL,
+	data TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS sessions (
+	id         TEXT PRIMARY KEY,
+	user       TEXT NOT NULL,
+	ip         TEXT NOT NULL,
+	user_agent TEXT NOT NULL,
+	created    INTEGER NOT NULL,
+	last_seen  INTEGER NOT NULL,
+	expires    INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id        TEXT PRIMARY KEY,
+	name      TEXT NOT NULL,
+	user      TEXT NOT NULL,
+	hash      TEXT NOT NULL UNIQUE,
+	scopes    TEXT NOT NULL,
+	ips       TEXT NOT NULL,
+	created   INTEGER NOT NULL,
+	expires   INTEGER NOT NULL DEFAULT 0,
+	last_used INTEGER NOT NULL DEFAULT 0,
+	last_ip   TEXT NOT NULL DEFAULT ''
+);
+`
+
+// *sql.DB 和 *sql.Tx 共有的写入方法,迁移时所有数据在同一个事务中写入
+type sqlExecer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// SQLiteStore 内嵌SQLite存储
+// 设置项按顶层键保存,任务按顺序逐行保存,允许同名任务
+type SQLiteStore struct {
+	db          *sql.DB
+	mu          sync.Mutex
+	cache       gjson.Result
+	dataVersion int64
+}
+
+// OpenSQLiteStore 打开或创建SQLite数据库
+func OpenSQLiteStore(path string) (*SQLiteStore, error) {
+	if err := pathutil.EnsureDir(pathutil.GetDataPath("")); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// 单连接保证PRAGMA data_version能反映其他进程的修改
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+func (s *SQLiteStore) Name() string {
+	return STORAGE_SQLITE
+}
+
+// Load 组装设置和任务为完整配置,数据库未变化时直接返回缓存
+func (s *SQLiteStore) Load() (gjson.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var version int64
+	if err := s.db.QueryRow("PRAGMA data_version").Scan(&version); err != nil {
+		return gjson.Parse(""), err
+	}
+	if s.cache.Exists() && version == s.dataVersion {
+		return s.cache, nil
+	}
+
+	empty, err := s.isEmpty()
+	if err != nil {
+		return gjson.Parse(""), err
+	}
+	if empty {
+		if err := s.save([]byte(defaultConfig)); err != nil {
+			return gjson.Parse(""), err
+		}
+	}
+
+	jsonStr := "{}"
+	rows, err := s.db.Query("SELECT key, value FROM settings")
+	if err != nil {
+		return gjson.Parse(""), err
+	}
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			rows.Close()
+			return gjson.Parse(""), err
+		}
+		jsonStr, _ = sjson.SetRaw(jsonStr, escapeKey(key), value)
+	}
+	rows.Close()
+
+	jsonStr, _ = sjson.SetRaw(jsonStr, "task", "[]")
+	rows, err = s.db.Query("SELECT data FROM tasks ORDER BY position")
+	if err != nil {
+		return gjson.Parse(""), err
+	}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			rows.Close()
+			return gjson.Parse(""), err
+		}
+		jsonStr, _ = sjson.SetRaw(jsonStr, "task.-1", data)
+	}
+	rows.Close()
+
+	s.cache = gjson.Parse(jsonStr)
+	s.dataVersion = version
+	return s.cache, nil
+}
+
+func (s *SQLiteStore) Save(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache = gjson.Result{}
+	return s.save(data)
+}
+
+// 在一个事务中替换全部设置和任务
+func (s *SQLiteStore) save(data []byte) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := saveConfigTx(tx, data); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// 在事务中替换全部设置和任务
+func saveConfigTx(tx sqlExecer, data []byte) error {
+	if !gjson.ValidBytes(data) {
+		return fmt.Errorf("配置不是有效的json")
+	}
+	cfg := gjson.ParseBytes(data)
+
+	if _, err := tx.Exec("DELETE FROM settings"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM tasks"); err != nil {
+		return err
+	}
+
+	var saveErr error
+	cfg.ForEach(func(key, value gjson.Result) bool {
+		if key.String() == "task" {
+			return true
+		}
+		_, saveErr = tx.Exec("INSERT INTO settings(key, value) VALUES(?, ?)", key.String(), value.Raw)
+		return saveErr == nil
+	})
+	if saveErr != nil {
+		return saveErr
+	}
+
+	for i, task := range cfg.Get("task").Array() {
+		_, err := tx.Exec("INSERT INTO tasks(position, name, data) VALUES(?, ?, ?)",
+			i, task.Get("name").String(), task.Raw)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 数据库中是否还没有配置
+func (s *SQLiteStore) isEmpty() (bool, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM settings").Scan(&count)
+	return count == 0, err
+}
+
+func (s *SQLiteStore) AddRun(rec RunRecord) error {
+	return addRun(s.db, rec)
+}
+
+func addRun(ex sqlExecer, rec RunRecord) error {
+	_, err := ex.Exec(`INSERT INTO runs(name, exec, trigger_by, started_at, ended_at, duration, success, error)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?)`,
+		rec.Name, rec.Exec, rec.Trigger, rec.Start.UnixMilli(), rec.End.UnixMilli(),
+		rec.Duration, rec.Success, rec.Error)
+	return err
+}
+
+func (s *SQLiteStore) ListRuns(q RunQuery) ([]RunRecord, error) {
+	query := "SELECT id, name, exec, trigger_by, started_at, ended_at, duration, success, error FROM runs"
+	var args []interface{}
+	if q.Name != "" {
+		query += " WHERE name = ?"
+		args = append(args, q.Name)
+	}
+	query += " ORDER BY id DESC"
+	if q.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", q.Limit)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []RunRecord
+	for rows.Next() {
+		var rec RunRecord
+		var start, end int64
+		if err := rows.Scan(&rec.ID, &rec.Name, &rec.Exec, &rec.Trigger, &start, &end,
+			&rec.Duration, &rec.Success, &rec.Error); err != nil {
+			return nil, err
+		}
+		rec.Start = time.UnixMilli(start)
+		rec.End = time.UnixMilli(end)
+		list = append(list, rec)
+	}
+	return list, rows.Err()
+}
+
+func (s *SQLiteStore) AddAudit(entry AuditEntry) error {
+	return addAudit(s.db, entry)
+}
+
+func addAudit(ex sqlExecer, entry AuditEntry) error {
+	_, err := ex.Exec(`INSERT INTO audit(time, user, ip, action, target, outcome, detail)
+		VALUES(?, ?, ?, ?, ?, ?, ?)`,
+		entry.Time.UnixMilli(), entry.User, entry.IP, entry.Action, entry.Target,
+		entry.Outcome, entry.Detail)
+	return err
+}
+
+func (s *SQLiteStore) ListAudit(q AuditQuery) ([]AuditEntry, error) {
+	var where []string
+	var args []interface{}
+	if q.User != "" {
+		where = append(where, "user = ?")
+		args = append(args, q.User)
+	}
+	if q.Action != "" {
+		where = append(where, "action = ?")
+		args = append(args, q.Action)
+	}
+	if q.Target != "" {
+		where = append(where, "target = ?")
+		args = append(args, q.Target)
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "time >= ?")
+		args = append(args, q.Since.UnixMilli())
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "time <= ?")
+		args = append(args, q.Until.UnixMilli())
+	}
+
+	query := "SELECT id, time, user, ip, action, target, outcome, detail FROM audit"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if q.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", q.Limit)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		var t int64
+		if err := rows.Scan(&entry.ID, &t, &entry.User, &entry.IP, &entry.Action,
+			&entry.Target, &entry.Outcome, &entry.Detail); err != nil {
+			return nil, err
+		}
+		entry.Time = time.UnixMilli(t)
+		list = append(list, entry)
+	}
+	return list, rows.Err()
+}
+
+func (s *SQLiteStore) AddRevision(rev Revision) error {
+	return addRevision(s.db, rev)
+}
+
+func addRevision(ex sqlExecer, rev Revision) error {
+	diff, err := json.Marshal(rev.Diff)
+	if err != nil {
+		return err
+	}
+	_, err = ex.Exec("INSERT INTO revisions(time, user, diff, data) VALUES(?, ?, ?, ?)",
+		rev.Time.UnixMilli(), rev.User, string(diff), string(rev.Data))
+	return err
+}
+
+func (s *SQLiteStore) ListRevisions(limit int) ([]Revision, error) {
+	query := "SELECT id, time, user, diff FROM revisions ORDER BY id DESC"
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Revision
+	for rows.Next() {
+		var rev Revision
+		var t int64
+		var diff string
+		if err := rows.Scan(&rev.ID, &t, &rev.User, &diff); err != nil {
+			return nil, err
+		}
+		rev.Time = time.UnixMilli(t)
+		json.Unmarshal([]byte(diff), &rev.Diff)
+		list = append(list, rev)
+	}
+	return list, rows.Err()
+}
+
+func (s *SQLiteStore) GetRevision(id int64) (Revision, error) {
+	var rev Revision
+	var t int64
+	var diff, data string
+	err := s.db.QueryRow("SELECT id, time, user, diff, data FROM revisions WHERE id = ?", id).
+		Scan(&rev.ID, &t, &rev.User, &diff, &data)
+	if err == sql.ErrNoRows {
+		return rev, ErrRevisionNotFound
+	}
+	if err != nil {
+		return rev, err
+	}
+	rev.Time = time.UnixMilli(t)
+	json.Unmarshal([]byte(diff), &rev.Diff)
+	rev.Data = json.RawMessage(data)
+	return rev, nil
+}
+
+// PruneRuns 删除指定时间之前的运行记录
+func (s *SQLiteStore) PruneRuns(before time.Time) error {
+	_, err := s.db.Exec("DELETE FROM runs WHERE started_at < ?", before.UnixMilli())
+	return err
+}
+
+// PruneAudit 删除指定时间之前的审计记录
+func (s *SQLiteStore) PruneAudit(before time.Time) error {
+	_, err := s.db.Exec("DELETE FROM audit WHERE time < ?", before.UnixMilli())
+	return err
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// 转义sjson路径中的特殊字符
+func escapeKey(key string) string {
+	replacer := strings.NewReplacer(".", `\.`, "*", `\*`, "?", `\?`, "|", `\|`, "#", `\#`, "@", `\@`)
+	return replacer.Replace(key)
+}
+
+// Snapshot 生成一致性的数据库快照文件
+func (s *SQLiteStore) Snapshot(dst string) error {
+	os.Remove(dst)
+	_, err := s.db.Exec("VACUUM INTO ?", dst)
+	return err
+}
@@ -0,0 +1,169 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"xuanwu/lib/pathutil"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	STORAGE_JSON   = "json"
+	STORAGE_SQLITE = "sqlite"
+)
+
+// RunRecord 任务运行记录
+type RunRecord struct {
+	ID       int64     `json:"id"`
+	Name     string    `json:"name"`     // 任务名称
+	Exec     string    `json:"exec"`     // 执行命令
+	Trigger  string    `json:"trigger"`  // 触发方式：cron/manual
+	Start    time.Time `json:"start"`    // 开始时间
+	End      time.Time `json:"end"`      // 结束时间
+	Duration int64     `json:"duration"` // 用时(毫秒)
+	Success  bool      `json:"success"`  // 是否成功
+	Error    string    `json:"error,omitempty"`
+}
+
+// RunQuery 运行记录查询条件
+type RunQuery struct {
+	Name  string // 任务名称,为空时查询全部
+	Limit int    // 返回条数,<=0时不限制
+}
+
+// AuditEntry 审计记录
+type AuditEntry struct {
+	ID      int64     `json:"id"`
+	Time    time.Time `json:"time"`
+	User    string    `json:"user"`
+	IP      string    `json:"ip"`
+	Action  string    `json:"action"`  // 操作,如 POST /api/cron/add
+	Target  string    `json:"target"`  // 操作对象,任务名或文件路径
+	Outcome string    `json:"outcome"` // 结果：success/failure
+	Detail  string    `json:"detail,omitempty"`
+}
+
+// AuditQuery 审计记录查询条件
+type AuditQuery struct {
+	User   string
+	Action string
+	Target string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// Store 配置存储后端
+type Store interface {
+	Name() string
+	Load() (gjson.Result, error) // 读取完整配置
+	Save(data []byte) error      // 写入完整配置
+	AddRun(rec RunRecord) error
+	ListRuns(q RunQuery) ([]RunRecord, error)
+	AddAudit(entry AuditEntry) error
+	ListAudit(q AuditQuery) ([]AuditEntry, error)
+	AddRevision(rev Revision) error
+	ListRevisions(limit int) ([]Revision, error) // 不包含完整配置
+	GetRevision(id int64) (Revision, error)
+	SaveSession(sess Session) error // 新增或更新会话
+	ListSessions() ([]Session, error)
+	DeleteSessions(ids ...string) error
+	DeleteExpiredSessions(now time.Time) error
+	SaveApiToken(token ApiToken) error // 新增或更新访问令牌
+	ListApiTokens() ([]ApiToken, error)
+	DeleteApiToken(id string) error
+	PruneRuns(before time.Time) error  // 删除该时间之前的运行记录
+	PruneAudit(before time.Time) error // 删除该时间之前的审计记录
+	Close() error
+}
+
+// Snapshotter 支持在线生成一致性快照的存储,备份时使用
+type Snapshotter interface {
+	Snapshot(dst string) error
+}
+
+var (
+	store     Store
+	storeLock sync.Mutex
+)
+
+// 默认配置
+const defaultConfig = `{
+	"name": "xuanwu",
+	"username":"admin",
+	"password":"8c6976e5b5410415bde908bd4dee15dfb167a9c873fc4bb8a81f6f2ab448a918",
+	"cookie_expire_days": 30,
+	"log_clean_days": 7,
+	"task": []
+}`
+
+// StorageType 获取存储类型
+// 优先级：环境变量 XW_STORAGE > 配置文件 storage > 默认 json
+func StorageType() string {
+	storage := strings.ToLower(os.Getenv("XW_STORAGE"))
+	if storage == "" {
+		if jsonByte, err := os.ReadFile(pathutil.GetConfigPath()); err == nil {
+			storage = strings.ToLower(gjson.GetBytes(jsonByte, "storage").String())
+		}
+	}
+	if storage == STORAGE_SQLITE {
+		return STORAGE_SQLITE
+	}
+	return STORAGE_JSON
+}
+
+// GetStore 获取当前存储后端,首次调用时初始化
+func GetStore() Store {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	if store != nil {
+		return store
+	}
+
+	if StorageType() == STORAGE_SQLITE {
+		s, err := OpenSQLiteStore(pathutil.GetDataPath(SQLITE_FILE))
+		if err == nil {
+			if err = migrateIfEmpty(s); err != nil {
+				log.Printf("迁移配置到SQLite失败: %v", err)
+			}
+			store = s
+			return store
+		}
+		log.Printf("打开SQLite存储失败,使用JSON存储: %v", err)
+	}
+
+	store = NewJSONStore(pathutil.GetConfigPath())
+	return store
+}
+
+// ReplaceDataFiles 关闭存储后执行 fn 替换数据文件,恢复备份时使用
+// 执行期间持有保存锁和存储锁,其他请求的 GetStore 和配置保存会等待替换完成,
+// 不会在文件替换到一半时重新打开存储或写入配置,完成后由下次 GetStore 打开新的文件
+func ReplaceDataFiles(fn func() error) error {
+	saveLock.Lock()
+	defer saveLock.Unlock()
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	if store != nil {
+		store.Close()
+		store = nil
+	}
+	return fn()
+}
+
+// CloseStore 关闭当前存储后端,下次调用GetStore时重新打开
+func CloseStore() error {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	if store == nil {
+		return nil
+	}
+	err := store.Close()
+	store = nil
+	return err
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"encoding/json"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// 用户角色
+const (
+	ROLE_ADMIN    = "admin"    // 管理员: 全部权限
+	ROLE_OPERATOR = "operator" // 操作员: 查看、执行、启用禁用任务
+	ROLE_VIEWER   = "viewer"   // 访客: 只能查看任务和日志
+)
+
+// User 用户账号,保存在配置的 users 数组中
+type User struct {
+	Username string `json:"username"`
+	Password string `json:"password"` // argon2id哈希,旧版配置为SHA256值
+	Role     string `json:"role"`
+	Disabled bool   `json:"disabled,omitempty"`
+
+	TOTPSecret    string   `json:"totp_secret,omitempty"`    // 两步验证密钥,为空表示未开启
+	RecoveryCodes []string `json:"recovery_codes,omitempty"` // 恢复码的SHA256值,使用后删除
+}
+
+// TOTPEnabled 是否已开启两步验证
+func (u User) TOTPEnabled() bool {
+	return u.TOTPSecret != ""
+}
+
+// IsValidRole 检查角色是否有效
+func IsValidRole(role string) bool {
+	return role == ROLE_ADMIN || role == ROLE_OPERATOR || role == ROLE_VIEWER
+}
+
+// GetUsers 获取用户列表
+// 旧版配置只有顶层的 username/password,视为唯一的管理员
+func GetUsers(cfg gjson.Result) []User {
+	users := []User{}
+	if list := cfg.Get("users"); list.IsArray() {
+		json.Unmarshal([]byte(list.Raw), &users)
+		return users
+	}
+	if name := cfg.Get("username").String(); name != "" {
+		users = append(users, User{
+			Username: name,
+			Password: cfg.Get("password").String(),
+			Role:     ROLE_ADMIN,
+		})
+	}
+	return users
+}
+
+// FindUser 按用户名查找用户
+func FindUser(cfg gjson.Result, username string) (User, bool) {
+	for _, user := range GetUsers(cfg) {
+		if user.Username == username {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+// SetUsers 写入用户列表,同时删除旧版的顶层 username/password
+func SetUsers(jsonStr string, users []User) (string, error) {
+	jsonStr, err := sjson.Set(jsonStr, "users", users)
+	if err != nil {
+		return jsonStr, err
+	}
+	jsonStr, _ = sjson.Delete(jsonStr, "username")
+	jsonStr, _ = sjson.Delete(jsonStr, "password")
+	return jsonStr, nil
+}
+
+// CountAdmins 统计未禁用的管理员数量
+func CountAdmins(users []User) int {
+	count := 0
+	for _, user := range users {
+		if user.Role == ROLE_ADMIN && !user.Disabled {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,321 @@
+package serve
+
+import (
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"xuanwu/config"
+	r "xuanwu/gin/response"
+	"xuanwu/lib"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 访问令牌前缀,用于和登录token区分
+const apiTokenPrefix = "xw_"
+
+// 令牌可用的权限范围
+var apiTokenScopes = []string{
+	PERM_CRON_READ, PERM_CRON_RUN, PERM_CRON_WRITE,
+	PERM_LOG_READ, PERM_FILE_READ, PERM_FILE_WRITE, PERM_ADMIN,
+}
+
+// 访问令牌缓存,按哈希索引
+type apiTokenCache struct {
+	mu    sync.Mutex
+	items map[string]*config.ApiToken
+	saved map[string]time.Time // 最近使用时间最后一次写入存储的时间
+}
+
+var apiTokens = &apiTokenCache{}
+
+// 加载令牌,调用方需持有锁
+func (m *apiTokenCache) load() {
+	if m.items != nil {
+		return
+	}
+	m.items = map[string]*config.ApiToken{}
+	m.saved = map[string]time.Time{}
+	list, err := config.GetStore().ListApiTokens()
+	if err != nil {
+		log.Printf("读取访问令牌失败: %v", err)
+		return
+	}
+	for i := range list {
+		m.items[list[i].Hash] = &list[i]
+	}
+}
+
+// Verify 校验令牌和来源IP,记录最近使用时间
+func (m *apiTokenCache) Verify(raw string, ip string) (config.ApiToken, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+
+	now := time.Now()
+	token, ok := m.items[lib.SHA256(raw)]
+	if !ok || token.IsExpired(now) || !ipAllowed(token.IPs, ip) {
+		return config.ApiToken{}, false
+	}
+	token.LastUsed = now
+	token.LastIP = ip
+	if now.Sub(m.saved[token.Hash]) >= sessionTouchInterval {
+		if err := config.GetStore().SaveApiToken(*token); err == nil {
+			m.saved[token.Hash] = now
+		}
+	}
+	return *token, true
+}
+
+// Create 生成新令牌,返回令牌明文,只在创建时返回一次
+func (m *apiTokenCache) Create(token config.ApiToken) (string, config.ApiToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+
+	raw := apiTokenPrefix + lib.RandomString(32)
+	token.ID = lib.RandomString(8)
+	token.Hash = lib.SHA256(raw)
+	token.Created = time.Now()
+	if err := config.GetStore().SaveApiToken(token); err != nil {
+		return "", token, err
+	}
+	m.items[token.Hash] = &token
+	return raw, token, nil
+}
+
+// List 获取令牌列表,user为空时返回全部
+func (m *apiTokenCache) List(user string) []config.ApiToken {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+
+	list := []config.ApiToken{}
+	for _, token := range m.items {
+		if user == "" || token.User == user {
+			t := *token
+			t.Hash = ""
+			list = append(list, t)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.After(list[j].Created)
+	})
+	return list
+}
+
+// Revoke 删除令牌,user不为空时只能删除该用户的令牌
+func (m *apiTokenCache) Revoke(id, user string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+
+	for hash, token := range m.items {
+		if token.ID != id || (user != "" && token.User != user) {
+			continue
+		}
+		if err := config.GetStore().DeleteApiToken(id); err != nil {
+			log.Printf("删除访问令牌失败: %v", err)
+			return false
+		}
+		delete(m.items, hash)
+		delete(m.saved, hash)
+		return true
+	}
+	return false
+}
+
+// RevokeUser 删除指定用户的全部令牌,用户删除后同名的新用户不能继续使用
+func (m *apiTokenCache) RevokeUser(user string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+
+	for hash, token := range m.items {
+		if token.User != user {
+			continue
+		}
+		if err := config.GetStore().DeleteApiToken(token.ID); err != nil {
+			return err
+		}
+		delete(m.items, hash)
+		delete(m.saved, hash)
+	}
+	return nil
+}
+
+// RenameUser 用户名修改后将令牌转到新用户名下
+func (m *apiTokenCache) RenameUser(oldName, newName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+
+	for _, token := range m.items {
+		if token.User != oldName {
+			continue
+		}
+		renamed := *token
+		renamed.User = newName
+		if err := config.GetStore().SaveApiToken(renamed); err != nil {
+			return err
+		}
+		token.User = newName
+	}
+	return nil
+}
+
+// Reset 清除缓存,下次使用时从存储重新加载
+func (m *apiTokenCache) Reset() {
+	m.mu.Lock()
+	m.items = nil
+	m.saved = nil
+	m.mu.Unlock()
+}
+
+// IP是否在允许列表中,支持单个IP和CIDR
+func ipAllowed(allowed []string, ip string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, item := range allowed {
+		if _, network, err := net.ParseCIDR(item); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+		} else if other := net.ParseIP(item); other != nil && other.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// 令牌的实际权限,不超过创建者角色的权限
+func apiTokenPermissions(token config.ApiToken, role string) permissions {
+	owner := newPermissions(rolePermissions[role])
+	perms := permissions{}
+	for _, scope := range token.Scopes {
+		if owner.Has(scope) {
+			perms[scope] = true
+		}
+	}
+	return perms
+}
+
+// 使用访问令牌认证,成功时在上下文中设置用户和权限
+func authByApiToken(c *gin.Context, raw string) bool {
+	token, ok := apiTokens.Verify(raw, c.ClientIP())
+	if !ok {
+		return false
+	}
+	user, ok := currentUser(token.User)
+	if !ok || user.Disabled {
+		return false
+	}
+	c.Set(r.UserKey, user.Username)
+	c.Set(r.ApiTokenKey, token.ID)
+	c.Set(r.PermKey, apiTokenPermissions(token, user.Role))
+	return true
+}
+
+// 令牌管理只允许网页登录操作,令牌不能创建令牌
+func requireSession(c *gin.Context) {
+	if c.GetString(r.ApiTokenKey) != "" {
+		r.ErrMesage(c, "请登录后操作")
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// HandlerApiTokenList 获取访问令牌列表,管理员可查看全部
+func HandlerApiTokenList(c *gin.Context) {
+	user := c.GetString(r.UserKey)
+	if HasPermission(c, PERM_ADMIN) {
+		user = ""
+	}
+	r.OkData(c, apiTokens.List(user))
+}
+
+// HandlerApiTokenCreate 创建访问令牌
+func HandlerApiTokenCreate(c *gin.Context) {
+	var req struct {
+		Name        string   `json:"name"`
+		Scopes      []string `json:"scopes"`
+		IPs         []string `json:"ips"`
+		ExpiresDays int      `json:"expires_days"` // 有效天数,0表示永不过期
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" || len(req.Scopes) == 0 {
+		r.ErrMesage(c, "请求参数错误")
+		return
+	}
+	if req.ExpiresDays < 0 {
+		r.ErrMesage(c, "有效天数不能小于0")
+		return
+	}
+
+	granted := getPermissions(c)
+	valid := newPermissions(apiTokenScopes)
+	for _, scope := range req.Scopes {
+		if !valid[scope] {
+			r.ErrMesage(c, "无效的权限范围: "+scope)
+			return
+		}
+		if !granted.Has(scope) {
+			r.ErrMesage(c, "没有该权限范围: "+scope)
+			return
+		}
+	}
+	for _, item := range req.IPs {
+		if _, _, err := net.ParseCIDR(item); err != nil && net.ParseIP(item) == nil {
+			r.ErrMesage(c, "无效的IP地址: "+item)
+			return
+		}
+	}
+
+	token := config.ApiToken{
+		Name:   strings.TrimSpace(req.Name),
+		User:   c.GetString(r.UserKey),
+		Scopes: req.Scopes,
+		IPs:    req.IPs,
+	}
+	if req.ExpiresDays > 0 {
+		token.Expires = time.Now().AddDate(0, 0, req.ExpiresDays)
+	}
+	raw, token, err := apiTokens.Create(token)
+	if err != nil {
+		r.ErrMesage(c, "创建令牌失败")
+		return
+	}
+	token.Hash = ""
+	r.OkMesageData(c, "创建成功,令牌只显示一次,请妥善保存", gin.H{
+		"token": raw,
+		"info":  token,
+	})
+}
+
+// HandlerApiTokenRevoke 删除访问令牌
+func HandlerApiTokenRevoke(c *gin.Context) {
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.ID == "" {
+		r.ErrMesage(c, "请求参数错误")
+		return
+	}
+	user := c.GetString(r.UserKey)
+	if HasPermission(c, PERM_ADMIN) {
+		user = ""
+	}
+	if !apiTokens.Revoke(req.ID, user) {
+		r.ErrMesage(c, "令牌不存在")
+		return
+	}
+	r.OkMesage(c, "删除成功")
+}
@@ -0,0 +1,193 @@
+package serve
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"xuanwu/config"
+	r "xuanwu/gin/response"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	AUDIT_SUCCESS = "success"
+	AUDIT_FAILURE = "failure"
+
+	auditBodyLimit     = 64 << 10 // 只解析不超过该大小的json请求体
+	auditResponseLimit = 4 << 10  // 只记录响应的前一部分,用于判断结果
+)
+
+// 请求参数中表示操作对象的字段,按顺序取第一个
+var auditTargetKeys = []string{"name", "path", "username", "id"}
+
+// 记录响应开头的内容,用于判断接口返回的 code
+type auditWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *auditWriter) Write(b []byte) (int, error) {
+	if n := auditResponseLimit - w.body.Len(); n > 0 {
+		if len(b) < n {
+			n = len(b)
+		}
+		w.body.Write(b[:n])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// 是否需要记录审计,修改数据的接口都不使用GET
+func shouldAudit(c *gin.Context) bool {
+	return c.FullPath() != "" && !isSafeMethod(c.Request.Method)
+}
+
+// AuditHandler 记录所有修改数据的接口调用
+func AuditHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !shouldAudit(c) {
+			c.Next()
+			return
+		}
+
+		// 先读取json请求体,处理函数读取后无法再获取,部分调用方不设置Content-Type
+		var body []byte
+		if !strings.HasPrefix(c.ContentType(), "multipart/") && c.Request.ContentLength <= auditBodyLimit {
+			body, _ = io.ReadAll(io.LimitReader(c.Request.Body, auditBodyLimit+1))
+			c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), c.Request.Body))
+			if len(body) > auditBodyLimit {
+				body = nil
+			}
+		}
+
+		// 修改用户名后上下文中仍为原用户名,记录时以操作前的用户为准
+		user := c.GetString(r.UserKey)
+		w := &auditWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		if user == "" {
+			user = c.GetString(r.UserKey) // 登录接口在成功后才设置用户
+		}
+
+		entry := config.AuditEntry{
+			Time:    time.Now(),
+			User:    user,
+			IP:      c.ClientIP(),
+			Action:  c.Request.Method + " " + c.FullPath(),
+			Target:  auditTarget(c, body),
+			Outcome: AUDIT_FAILURE,
+		}
+		res := gjson.ParseBytes(w.body.Bytes())
+		if c.Writer.Status() < http.StatusBadRequest && res.Get("code").Exists() && res.Get("code").Int() == 0 {
+			entry.Outcome = AUDIT_SUCCESS
+		}
+		if msg := res.Get("message").String(); msg != "" {
+			entry.Detail = msg
+		} else if entry.Outcome == AUDIT_FAILURE {
+			entry.Detail = http.StatusText(c.Writer.Status())
+		}
+		if err := config.GetStore().AddAudit(entry); err != nil {
+			log.Printf("保存审计记录失败: %v", err)
+		}
+	}
+}
+
+// 从请求参数中获取操作对象,如任务名、文件路径、用户名
+func auditTarget(c *gin.Context, body []byte) string {
+	for _, key := range auditTargetKeys {
+		if v := c.Query(key); v != "" {
+			return v
+		}
+	}
+
+	if len(body) > 0 {
+		data := gjson.ParseBytes(body)
+		for _, key := range auditTargetKeys {
+			if v := data.Get(key).String(); v != "" {
+				if newPath := data.Get("new_path").String(); newPath != "" {
+					return v + " -> " + newPath
+				}
+				return v
+			}
+		}
+		// 批量添加任务
+		if names := data.Get("tasks.#.name").Array(); len(names) > 0 {
+			list := make([]string, 0, len(names))
+			for _, name := range names {
+				list = append(list, name.String())
+			}
+			return strings.Join(list, ",")
+		}
+		return ""
+	}
+
+	// 上传文件,处理函数已解析过表单
+	if form := c.Request.MultipartForm; form != nil {
+		dir := "."
+		if v := form.Value["path"]; len(v) > 0 && v[0] != "" {
+			dir = v[0]
+		}
+		var names []string
+		for _, files := range form.File {
+			for _, file := range files {
+				names = append(names, path.Join(dir, file.Filename))
+			}
+		}
+		if len(names) > 0 {
+			return strings.Join(names, ",")
+		}
+		return dir
+	}
+	return ""
+}
+
+// 解析时间参数,支持 RFC3339 和日期
+func parseAuditTime(value string, endOfDay bool) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, true
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, true
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		if endOfDay {
+			t = t.Add(24*time.Hour - time.Millisecond)
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// HandlerAuditList 查询审计记录
+func HandlerAuditList(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	since, ok1 := parseAuditTime(c.Query("since"), false)
+	until, ok2 := parseAuditTime(c.Query("until"), true)
+	if !ok1 || !ok2 {
+		r.ErrMesage(c, "时间格式错误")
+		return
+	}
+
+	list, err := config.GetStore().ListAudit(config.AuditQuery{
+		User:   c.Query("user"),
+		Action: c.Query("action"),
+		Target: c.Query("target"),
+		Since:  since,
+		Until:  until,
+		Limit:  limit,
+	})
+	if err != nil {
+		r.ErrMesage(c, "读取审计记录失败")
+		return
+	}
+	if list == nil {
+		list = []config.AuditEntry{}
+	}
+	r.OkData(c, list)
+}
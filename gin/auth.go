@@ -1,9 +1,10 @@
 package serve
 
 import (
-	"fmt"
+	"log"
 	"strings"
 	"time"
+	"xuanwu/config"
 	r "xuanwu/gin/response"
 	"xuanwu/lib"
 
@@ -12,15 +13,26 @@ import (
 
 // ClearUserToken 清除用户token
 func (p *ApiData) ClearUserToken(c *gin.Context) {
-	// 获取当前token
-	cookie, err := c.Cookie("cookie")
-	if err == nil {
-		// 将token加入黑名单
-		lib.GetTokenBlacklist().AddToBlacklist(cookie)
+	// 注销当前会话
+	if id := c.GetString(r.SessionKey); id != "" {
+		sessions.Revoke(id)
 	}
 
 	// 清除cookie
-	c.SetCookie("cookie", "", -1, "/", "", false, false)
+	setAuthCookie(c, "", -1)
+	clearCSRFCookie(c)
+}
+
+// 用户名或密码错误的统一提示
+const errLoginFailed = "用户名或密码错误"
+
+// 不需要登录即可访问的接口
+var publicPaths = map[string]bool{
+	"/api/auth/login":         true,
+	"/api/auth/login/totp":    true,
+	"/api/auth/oidc":          true,
+	"/api/auth/oidc/login":    true,
+	"/api/auth/oidc/callback": true,
 }
 
 func (p *ApiData) CookieHandler() gin.HandlerFunc {
@@ -28,12 +40,22 @@ func (p *ApiData) CookieHandler() gin.HandlerFunc {
 		if strings.HasPrefix(c.Request.RequestURI, "/api") {
 			cookie, err := c.Cookie("cookie")
 			//cookie不存在,用户认证失败
-			if c.FullPath() != "/api/auth/login" {
+			if !publicPaths[c.FullPath()] {
+				//反代传来的用户名请求头,浏览器会自动携带反代的登录状态,同样需要校验CSRF令牌
+				if authByHeader(c) {
+					if !checkCSRF(c) {
+						abortCSRF(c)
+						return
+					}
+					c.Next()
+					return
+				}
+
+				fromCookie := err == nil
 				if err != nil {
-					//如果cookie为空,就获取Authorization
-					if _, ok := c.Request.Header["Authorization"]; ok {
-						// 存在
-						cookie = c.Request.Header["Authorization"][0]
+					//如果cookie为空,就获取Authorization,支持 Bearer 前缀
+					if auth := c.GetHeader("Authorization"); auth != "" {
+						cookie = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
 					} else {
 						//除过login其他都要鉴权
 						r.AuthMesage(c)
@@ -42,22 +64,39 @@ func (p *ApiData) CookieHandler() gin.HandlerFunc {
 					}
 				}
 
-				// 检查token是否在黑名单中
-				if lib.GetTokenBlacklist().IsBlacklisted(cookie) {
+				//个人访问令牌
+				if strings.HasPrefix(cookie, apiTokenPrefix) {
+					if !authByApiToken(c, cookie) {
+						r.AuthMesage(c)
+						c.Abort()
+						return
+					}
+					c.Next()
+					return
+				}
+
+				//校验签名和有效期,会话被注销时同样失效
+				claims, err := lib.ParseToken(cookie)
+				if err != nil || !sessions.Touch(claims.ID, c) {
 					r.AuthMesage(c)
 					c.Abort()
 					return
 				}
-
-				//解密
-				username, err := lib.DecryptByAes(cookie)
-				if err != nil {
+				//用户被删除或禁用后立即失效
+				user, ok := currentUser(claims.User)
+				if !ok || user.Disabled {
 					r.AuthMesage(c)
 					c.Abort()
 					return
 				}
-				p.Cookie = string(username)
-				p.Token = cookie
+				//浏览器会自动携带cookie,修改请求需要校验CSRF令牌
+				if fromCookie && !checkCSRF(c) {
+					abortCSRF(c)
+					return
+				}
+				c.Set(r.UserKey, user.Username)
+				c.Set(r.SessionKey, claims.ID)
+				c.Set(r.PermKey, newPermissions(rolePermissions[user.Role]))
 			}
 		}
 		// after request  请求前处理
@@ -77,32 +116,84 @@ func (p *ApiData) LoginHandle(c *gin.Context) {
 		r.ErrMesage(c, "请求参数错误")
 		return
 	}
-	res := GetUserInfo()
-	if res.Username != req.Username { //没有查到用户数据
-		r.ErrMesage(c, "用户名错误")
+	ip := c.ClientIP()
+	if msg, ok := loginLimit.Check(ip, req.Username); !ok {
+		r.ErrMesage(c, msg)
+		return
+	}
+	//用户名或密码错误时返回相同的提示,避免探测用户名
+	user, ok := currentUser(req.Username)
+	if !ok { //没有查到用户数据
+		verifyDummyPassword(req.Password)
+		loginLimit.Fail(ip, req.Username)
+		r.ErrMesage(c, errLoginFailed)
+		return
+	}
+	//传过来的参数是sha256,与服务端保存的argon2id哈希比较
+	ok, needRehash := lib.VerifyPassword(user.Password, req.Password)
+	if !ok {
+		loginLimit.Fail(ip, req.Username)
+		r.ErrMesage(c, errLoginFailed)
 		return
 	}
-	//密码在设置时候就加密存储,传过来的参数是sha256,直接比较
-	if res.Password != req.Password {
-		r.ErrMesage(c, "密码错误")
+	if user.Disabled {
+		r.ErrMesage(c, "用户已被禁用")
 		return
 	}
+	// 旧版配置保存的是sha256值,登录成功后升级为argon2id
+	if needRehash {
+		if err := upgradePasswordHash(req.Username, req.Password); err != nil {
+			log.Printf("升级密码哈希失败: %v", err)
+		}
+	}
+
+	// 开启两步验证时先返回票据,输入验证码后再签发token
+	if user.TOTPEnabled() {
+		r.OkMesageData(c, "请输入两步验证码", gin.H{
+			"need_totp": true,
+			"ticket":    totp.newTicket(user.Username),
+		})
+		return
+	}
+	loginLimit.Success(user.Username)
+	issueLogin(c, user)
+}
 
-	// 生成token时加入时间戳确保唯一性
-	tokenStr := fmt.Sprintf("%s_%d", req.Username, time.Now().Unix())
-	//加密
-	str, _ := lib.EncryptByAes([]byte(tokenStr))
+// 签发token并创建会话
+func issueLogin(c *gin.Context, user config.User) {
+	data, err := createLoginSession(c, user)
+	if err != nil {
+		log.Printf("登录失败: %v", err)
+		r.ErrMesage(c, "登录失败")
+		return
+	}
+	r.OkMesageData(c, "登录成功", data)
+}
 
-	// 使用全局配置的Cookie过期时间
+// 签发token、保存会话并设置cookie,返回给前端的登录信息
+func createLoginSession(c *gin.Context, user config.User) (gin.H, error) {
+	// 使用全局配置的Cookie过期时间,token中同样记录过期时间
 	expireSeconds := GetCookieExpireDays() * 24 * 60 * 60
+	//签发token
+	str, claims, err := lib.SignToken(user.Username, time.Duration(expireSeconds)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if err := sessions.Create(claims, c); err != nil {
+		return nil, err
+	}
 
 	//设置cookie
-	c.SetCookie("cookie", str, expireSeconds, "/", "", false, false)
-
-	r.OkMesageData(c, "登录成功", gin.H{
-		"token":  str,
-		"maxAge": expireSeconds,
-	})
+	setAuthCookie(c, str, expireSeconds)
+	csrfToken := setCSRFCookie(c, expireSeconds)
+	c.Set(r.UserKey, user.Username)
+
+	return gin.H{
+		"token":      str,
+		"maxAge":     expireSeconds,
+		"role":       user.Role,
+		"csrf_token": csrfToken, // 使用cookie登录时修改请求需带上请求头 X-CSRF-Token
+	}, nil
 }
 
 // 退出登录方法
@@ -111,19 +202,45 @@ func (p *ApiData) LogoutHandler(c *gin.Context) {
 	r.OkMesage(c, "退出登录成功")
 }
 
+// 更换token签名密钥,所有已登录的会话失效
+func (p *ApiData) HandlerRotateSecret(c *gin.Context) {
+	if err := lib.RotateSecret(); err != nil {
+		r.ErrMesage(c, "更换密钥失败")
+		return
+	}
+	sessions.RevokeAll()
+	p.ClearUserToken(c)
+	r.OkMesage(c, "密钥已更换,请重新登录")
+}
+
 // 检查是否为默认用户名密码
 func (p *ApiData) CheckDefaultCredentials(c *gin.Context) {
 	// 定义默认用户名和密码
 	defaultUsername := "admin"
-	defaultPassword := lib.SHA256("admin") // admin的SHA256值
+	defaultPassword := lib.SHA256("admin") // admin的SHA256值,网页登录时传来的值
 
 	// 获取当前用户信息
-	userInfo := GetUserInfo()
+	user, _ := currentUser(c.GetString(r.UserKey))
 
 	// 检查用户名和密码是否都为默认值
-	isDefault := userInfo.Username == defaultUsername && userInfo.Password == defaultPassword
+	isDefault := false
+	if user.Username == defaultUsername {
+		isDefault, _ = lib.VerifyPassword(user.Password, defaultPassword)
+	}
 
 	r.OkData(c, gin.H{
 		"is_default": isDefault,
 	})
 }
+
+// 将密码重新哈希后保存
+func upgradePasswordHash(username, password string) error {
+	hash, err := lib.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return updateUser(username, username, func(u *config.User) error {
+		u.Password = hash
+		return nil
+	})
+}
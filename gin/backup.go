@@ -0,0 +1,71 @@
+package serve
+
+import (
+	"log"
+	"os"
+	r "xuanwu/gin/response"
+	"xuanwu/lib/backup"
+	"xuanwu/lib/pathutil"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandlerBackup 打包下载整个数据目录
+func HandlerBackup(c *gin.Context) {
+	format := backup.NormalizeFormat(c.Query("format"))
+	if format == "" {
+		r.ErrMesage(c, "不支持的备份格式")
+		return
+	}
+	opts := backup.Options{
+		Format:      format,
+		ExcludeLogs: c.Query("exclude_logs") == "true" || c.Query("exclude_logs") == "1",
+	}
+
+	contentType := "application/gzip"
+	if format == backup.FORMAT_ZIP {
+		contentType = "application/zip"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", "attachment; filename="+backup.FileName(format))
+	c.Status(200)
+
+	if err := backup.Write(c.Writer, opts); err != nil {
+		// 已经开始输出,只能记录日志并中断连接
+		log.Printf("备份失败: %v", err)
+		c.Abort()
+	}
+}
+
+// HandlerRestore 上传备份文件并恢复数据目录
+func HandlerRestore(c *gin.Context) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		r.ErrMesage(c, "获取上传文件失败")
+		return
+	}
+
+	// 使用随机文件名,不使用客户端提供的文件名
+	f, err := os.CreateTemp("", "xuanwu-restore-*")
+	if err != nil {
+		r.ErrMesage(c, "保存文件失败")
+		return
+	}
+	tmp := f.Name()
+	f.Close()
+	defer os.Remove(tmp)
+	if err := c.SaveUploadedFile(file, tmp); err != nil {
+		r.ErrMesage(c, "保存文件失败")
+		return
+	}
+
+	if err := backup.Restore(tmp); err != nil {
+		log.Printf("恢复备份失败: %v", err)
+		r.ErrMesage(c, "恢复失败: "+err.Error())
+		return
+	}
+	ReloadConfig()
+
+	log.Printf("已从备份恢复数据目录: %s", pathutil.GetDataPath(""))
+	r.OkMesage(c, "恢复成功")
+}
@@ -0,0 +1,109 @@
+package serve
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// 访问路径前缀,如 /xuanwu,为空时挂载在根路径
+var basePath string
+
+// 首页中以 / 开头的资源地址(不包含 // 开头的协议相对地址)和接口地址
+var (
+	indexAssetPattern = regexp.MustCompile(`((?:src|href|action)=["'])/([^/"'])`)
+	indexApiPattern   = regexp.MustCompile(`(["'` + "`" + `])/api/`)
+)
+
+// NormalizeBasePath 整理路径前缀,返回以 / 开头且不以 / 结尾的路径,根路径返回空
+func NormalizeBasePath(value string) (string, error) {
+	value = strings.Trim(strings.TrimSpace(value), "/")
+	if value == "" {
+		return "", nil
+	}
+	if strings.ContainsAny(value, "?#%\\ ") {
+		return "", fmt.Errorf("base_path 包含无效字符: %s", value)
+	}
+	for _, part := range strings.Split(value, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", fmt.Errorf("base_path 格式错误: %s", value)
+		}
+	}
+	return "/" + value, nil
+}
+
+// 读取路径前缀,优先级：环境变量 XW_BASE_PATH > 配置文件 base_path
+func loadBasePath(cfg gjson.Result) error {
+	value := cfg.Get("base_path").String()
+	if env, ok := os.LookupEnv("XW_BASE_PATH"); ok {
+		value = env
+	}
+	p, err := NormalizeBasePath(value)
+	if err != nil {
+		return err
+	}
+	basePath = p
+	return nil
+}
+
+// cookie 的路径,只在前缀下发送
+func cookiePath() string {
+	return basePath + "/"
+}
+
+// 去掉请求路径中的前缀后交给路由处理,前缀外的请求除探针外返回404
+func basePathHandler(h http.Handler) http.Handler {
+	if basePath == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if probePaths[req.URL.Path] {
+			h.ServeHTTP(w, req)
+			return
+		}
+		if req.URL.Path == basePath {
+			target := basePath + "/"
+			if req.URL.RawQuery != "" {
+				target += "?" + req.URL.RawQuery
+			}
+			http.Redirect(w, req, target, http.StatusMovedPermanently)
+			return
+		}
+		rest, ok := strings.CutPrefix(req.URL.Path, basePath+"/")
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+
+		r2 := new(http.Request)
+		*r2 = *req
+		r2.URL = new(url.URL)
+		*r2.URL = *req.URL
+		r2.URL.Path = "/" + rest
+		if req.URL.RawPath != "" {
+			r2.URL.RawPath = "/" + strings.TrimPrefix(req.URL.RawPath, basePath+"/")
+		}
+		r2.RequestURI = r2.URL.RequestURI()
+		h.ServeHTTP(w, r2)
+	})
+}
+
+// 为首页中的资源和接口地址加上前缀,并告知前端当前前缀
+func rewriteIndex(content []byte) []byte {
+	if basePath == "" {
+		return content
+	}
+	html := indexAssetPattern.ReplaceAllString(string(content), "${1}"+basePath+"/${2}")
+	html = indexApiPattern.ReplaceAllString(html, "${1}"+basePath+"/api/")
+	inject := `<base href="` + basePath + `/"><script>window.__BASE_PATH__="` + basePath + `"</script>`
+	if i := strings.Index(strings.ToLower(html), "<head>"); i >= 0 {
+		i += len("<head>")
+		return []byte(html[:i] + inject + html[i:])
+	}
+	return []byte(inject + html)
+}
@@ -2,12 +2,10 @@ package cron
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"log"
 	"xuanwu/config"
 	r "xuanwu/gin/response"
-	"xuanwu/lib/pathutil"
 	mycron "xuanwu/xuanwu"
 
 	"github.com/gin-gonic/gin"
@@ -65,63 +63,43 @@ func HandlerAddTask(c *gin.Context) {
 	jp.Set("exec", jsonData["exec"])
 	jp.Set("enable", jsonData["enable"])
 
-	cfg, err := config.ReadConfigFileToJson()
-	if err != nil {
-		log.Println("读取配置文件出错")
-		return
-	}
-
 	// 检查任务是否已存在
 	isUpdate := false
-	result := gjson.Get(cfg.Raw, "task.#.name")
-	for i, isname := range result.Array() {
-		if isname.String() == name {
-			isUpdate = true
-			// 更新配置文件
-			jp := &JsonParams{data: cfg.Raw}
-			jp.Set(fmt.Sprintf("task.%v.times", i), times)
-			jp.Set(fmt.Sprintf("task.%v.workdir", i), workdir)
-			jp.Set(fmt.Sprintf("task.%v.exec", i), exec)
-			jp.Set(fmt.Sprintf("task.%v.enable", i), jsonData["enable"])
-			configPath := pathutil.GetConfigPath()
-			err := config.WriteConfigFile(configPath, []byte(jp.data))
-			if err != nil {
-				r.ErrMesage(c, "更新失败,配置文件写入失败")
-				return
+	err := config.UpdateConfig(c.GetString(r.UserKey), func(cfg gjson.Result) ([]byte, error) {
+		isUpdate = false
+		result := gjson.Get(cfg.Raw, "task.#.name")
+		for i, isname := range result.Array() {
+			if isname.String() == name {
+				isUpdate = true
+				// 更新配置文件
+				update := &JsonParams{data: cfg.Raw}
+				update.Set(fmt.Sprintf("task.%v.times", i), times)
+				update.Set(fmt.Sprintf("task.%v.workdir", i), workdir)
+				update.Set(fmt.Sprintf("task.%v.exec", i), exec)
+				update.Set(fmt.Sprintf("task.%v.enable", i), jsonData["enable"])
+				return []byte(update.data), nil
 			}
-			break
 		}
-	}
 
-	if !isUpdate {
 		// 添加新任务
 		var newObj map[string]interface{}
 		json.Unmarshal([]byte(jp.data), &newObj)
-		value, _ := sjson.Set(cfg.Raw, "task.-1", newObj)
-		configPath := pathutil.GetConfigPath()
-		err = config.WriteConfigFile(configPath, []byte(value))
-		if err != nil {
+		value, err := sjson.Set(cfg.Raw, "task.-1", newObj)
+		return []byte(value), err
+	})
+	if err != nil {
+		if isUpdate {
+			r.ErrMesage(c, "更新失败,配置文件写入失败")
+		} else {
 			r.ErrMesage(c, "添加失败,配置文件写入失败")
-			return
 		}
+		return
 	}
 
 	// 如果enable为true，启用任务
 	if enable, ok := jsonData["enable"].(bool); ok && enable {
 		// 先禁用任务（如果存在）
-		for _, e := range mycron.C.Entries() {
-			if taskInfo, exists := mycron.TaskData[e.ID]; exists && taskInfo.Name == name {
-				mycron.C.Remove(e.ID)
-				// 关闭日志文件
-				if taskInfo.Writer != nil {
-					taskInfo.Writer.Close()
-				}
-				// 停止写入日志
-				taskInfo.Log.SetOutput(io.Discard)
-				// 从映射表中删除
-				delete(mycron.TaskData, e.ID)
-			}
-		}
+		mycron.RemoveTask(name)
 
 		// 添加到cron
 		TaskData := mycron.TaskInfo{
@@ -176,138 +154,125 @@ func HandlerBatchAddTask(c *gin.Context) {
 	// 用于记录批量操作结果
 	var successTasks []string
 	var failedTasks []map[string]interface{}
+	// 保存成功后需要加入cron的任务
+	var enabledTasks []mycron.TaskInfo
 
-	cfg, err := config.ReadConfigFileToJson()
-	if err != nil {
-		log.Println("读取配置文件出错")
-		r.ErrMesage(c, "读取配置文件失败")
-		return
-	}
-
-	configStr := cfg.Raw
-
-	// 遍历处理每个任务
-	for _, taskData := range jsonData.Tasks {
-		// 验证必填字段
-		name, nameOk := taskData["name"].(string)
-		if !nameOk || name == "" {
-			failedTasks = append(failedTasks, map[string]interface{}{
-				"task": taskData,
-				"error": "任务名称不能为空",
-			})
-			continue
-		}
-
-		times := taskData["times"]
-		if times == nil {
-			failedTasks = append(failedTasks, map[string]interface{}{
-				"task": taskData,
-				"error": "任务类型不能为空",
-			})
-			continue
-		}
+	err := config.UpdateConfig(c.GetString(r.UserKey), func(cfg gjson.Result) ([]byte, error) {
+		successTasks, failedTasks, enabledTasks = nil, nil, nil
+		configStr := cfg.Raw
 
-		workdir := taskData["workdir"]
-		if workdir == nil {
-			failedTasks = append(failedTasks, map[string]interface{}{
-				"task": taskData,
-				"error": "工作目录不能为空",
-			})
-			continue
-		}
+		// 遍历处理每个任务
+		for _, taskData := range jsonData.Tasks {
+			// 验证必填字段
+			name, nameOk := taskData["name"].(string)
+			if !nameOk || name == "" {
+				failedTasks = append(failedTasks, map[string]interface{}{
+					"task": taskData,
+					"error": "任务名称不能为空",
+				})
+				continue
+			}
 
-		exec := taskData["exec"]
-		if exec == nil {
-			failedTasks = append(failedTasks, map[string]interface{}{
-				"task": taskData,
-				"error": "执行命令不能为空",
-			})
-			continue
-		}
+			times := taskData["times"]
+			if times == nil {
+				failedTasks = append(failedTasks, map[string]interface{}{
+					"task": taskData,
+					"error": "任务类型不能为空",
+				})
+				continue
+			}
 
-		// 构建任务对象
-		jp := &JsonParams{data: ""}
-		jp.Set("name", name)
-		jp.Set("times", times)
-		jp.Set("workdir", workdir)
-		jp.Set("exec", exec)
-		jp.Set("enable", taskData["enable"])
+			workdir := taskData["workdir"]
+			if workdir == nil {
+				failedTasks = append(failedTasks, map[string]interface{}{
+					"task": taskData,
+					"error": "工作目录不能为空",
+				})
+				continue
+			}
 
-		// 检查任务是否已存在
-		isUpdate := false
-		result := gjson.Get(configStr, "task.#.name")
-		for i, isname := range result.Array() {
-			if isname.String() == name {
-				isUpdate = true
-				// 更新配置文件
-				jpUpdate := &JsonParams{data: configStr}
-				jpUpdate.Set(fmt.Sprintf("task.%v.times", i), times)
-				jpUpdate.Set(fmt.Sprintf("task.%v.workdir", i), workdir)
-				jpUpdate.Set(fmt.Sprintf("task.%v.exec", i), exec)
-				jpUpdate.Set(fmt.Sprintf("task.%v.enable", i), taskData["enable"])
-				configStr = jpUpdate.data
-				break
+			exec := taskData["exec"]
+			if exec == nil {
+				failedTasks = append(failedTasks, map[string]interface{}{
+					"task": taskData,
+					"error": "执行命令不能为空",
+				})
+				continue
 			}
-		}
 
-		if !isUpdate {
-			// 添加新任务
-			var newObj map[string]interface{}
-			json.Unmarshal([]byte(jp.data), &newObj)
-			configStr, _ = sjson.Set(configStr, "task.-1", newObj)
-		}
+			// 构建任务对象
+			jp := &JsonParams{data: ""}
+			jp.Set("name", name)
+			jp.Set("times", times)
+			jp.Set("workdir", workdir)
+			jp.Set("exec", exec)
+			jp.Set("enable", taskData["enable"])
 
-		// 如果enable为true，启用任务
-		if enable, ok := taskData["enable"].(bool); ok && enable {
-			// 先禁用任务（如果存在）
-			for _, e := range mycron.C.Entries() {
-				if taskInfo, exists := mycron.TaskData[e.ID]; exists && taskInfo.Name == name {
-					mycron.C.Remove(e.ID)
-					// 关闭日志文件
-					if taskInfo.Writer != nil {
-						taskInfo.Writer.Close()
-					}
-					// 停止写入日志
-					taskInfo.Log.SetOutput(io.Discard)
-					// 从映射表中删除
-					delete(mycron.TaskData, e.ID)
+			// 检查任务是否已存在
+			isUpdate := false
+			result := gjson.Get(configStr, "task.#.name")
+			for i, isname := range result.Array() {
+				if isname.String() == name {
+					isUpdate = true
+					// 更新配置文件
+					jpUpdate := &JsonParams{data: configStr}
+					jpUpdate.Set(fmt.Sprintf("task.%v.times", i), times)
+					jpUpdate.Set(fmt.Sprintf("task.%v.workdir", i), workdir)
+					jpUpdate.Set(fmt.Sprintf("task.%v.exec", i), exec)
+					jpUpdate.Set(fmt.Sprintf("task.%v.enable", i), taskData["enable"])
+					configStr = jpUpdate.data
+					break
 				}
 			}
 
-			// 添加到cron
-			TaskData := mycron.TaskInfo{
-				Name: name,
-				Times: func() []string {
-					timesArray, ok := times.([]interface{})
-					if !ok {
-						return []string{}
-					}
-					var result []string
-					for _, t := range timesArray {
-						if str, ok := t.(string); ok {
-							result = append(result, str)
-						}
-					}
-					return result
-				}(),
-				WorkDir: workdir.(string),
-				Exec:    exec.(string),
-				Enable:  true,
+			if !isUpdate {
+				// 添加新任务
+				var newObj map[string]interface{}
+				json.Unmarshal([]byte(jp.data), &newObj)
+				configStr, _ = sjson.Set(configStr, "task.-1", newObj)
 			}
-			mycron.AddRunFunc(TaskData)
-		}
 
-		successTasks = append(successTasks, name)
-	}
+			// 如果enable为true，保存后启用任务
+			if enable, ok := taskData["enable"].(bool); ok && enable {
+				workdirStr, _ := workdir.(string)
+				execStr, _ := exec.(string)
+				enabledTasks = append(enabledTasks, mycron.TaskInfo{
+					Name: name,
+					Times: func() []string {
+						timesArray, ok := times.([]interface{})
+						if !ok {
+							return []string{}
+						}
+						var result []string
+						for _, t := range timesArray {
+							if str, ok := t.(string); ok {
+								result = append(result, str)
+							}
+						}
+						return result
+					}(),
+					WorkDir: workdirStr,
+					Exec:    execStr,
+					Enable:  true,
+				})
+			}
 
-	// 写入配置文件
-	configPath := pathutil.GetConfigPath()
-	err = config.WriteConfigFile(configPath, []byte(configStr))
+			successTasks = append(successTasks, name)
+		}
+		return []byte(configStr), nil
+	})
 	if err != nil {
 		r.ErrMesage(c, "批量添加失败,配置文件写入失败")
 		return
 	}
 
+	for _, task := range enabledTasks {
+		// 先禁用任务（如果存在）
+		mycron.RemoveTask(task.Name)
+		// 添加到cron
+		mycron.AddRunFunc(task)
+	}
+
 	// 返回批量操作结果
 	r.OkMesageData(c, "批量操作完成", gin.H{
 		"success": successTasks,
@@ -320,29 +285,41 @@ func HandlerBatchAddTask(c *gin.Context) {
 
 /* 删除任务源 */
 func HandlerDeleteTask(c *gin.Context) {
-	name := c.Query("name")
+	name := bindTaskName(c)
 	if name == "" {
 		r.ErrMesage(c, "任务名称不能为空")
 		return
 	}
-	cfg, err := config.ReadConfigFileToJson()
+	err := config.UpdateConfig(c.GetString(r.UserKey), func(cfg gjson.Result) ([]byte, error) {
+		result := gjson.Get(cfg.Raw, "task.#.name")
+		for i, isname := range result.Array() {
+			if isname.String() == name {
+				value, err := sjson.Delete(cfg.Raw, fmt.Sprintf("task.%v", i))
+				return []byte(value), err
+			}
+		}
+		return nil, errTaskNotFound
+	})
+	if errors.Is(err, errTaskNotFound) {
+		r.ErrMesage(c, "删除失败,任务不存在")
+		return
+	}
 	if err != nil {
-		log.Println("读取配置文件出错")
+		r.ErrMesage(c, "删除失败,配置文件写入失败")
 		return
 	}
-	result := gjson.Get(cfg.Raw, "task.#.name")
-	for i, isname := range result.Array() {
-		if isname.String() == name {
-			value, _ := sjson.Delete(cfg.Raw, fmt.Sprintf("task.%v", i))
-			configPath := pathutil.GetConfigPath()
-			err := config.WriteConfigFile(configPath, []byte(value))
-			if err != nil {
-				r.ErrMesage(c, "删除失败,配置文件写入失败")
-				return
-			}
-			r.OkMesage(c, "删除成功")
-			return
-		}
+	r.OkMesage(c, "删除成功")
+}
+
+var errTaskNotFound = errors.New("任务不存在")
+
+// 从请求体中获取任务名称,支持JSON和表单
+func bindTaskName(c *gin.Context) string {
+	var req struct {
+		Name string `json:"name" form:"name"`
+	}
+	if err := c.ShouldBind(&req); err != nil {
+		return ""
 	}
-	r.ErrMesage(c, "删除失败,任务不存在")
+	return req.Name
 }
@@ -0,0 +1,369 @@
+package cron
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"xuanwu/config"
+	r "xuanwu/gin/response"
+	"xuanwu/lib/pathutil"
+	mycron "xuanwu/xuanwu"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// 青龙环境变量导入结果
+type envPlan struct {
+	Add    []string `json:"add"`
+	Update []string `json:"update"`
+	Skip   []string `json:"skip"`
+}
+
+// 无法转换的青龙任务或环境变量
+type unconverted struct {
+	Name    string `json:"name"`
+	Command string `json:"command,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+/*
+导入青龙面板的任务和环境变量
+crons: 青龙定时任务导出的json, envs: 青龙环境变量导出的json
+dry_run为true时只返回预览
+*/
+func HandlerImportQinglong(c *gin.Context) {
+	var req struct {
+		Crons  json.RawMessage `json:"crons"`
+		Envs   json.RawMessage `json:"envs"`
+		DryRun bool            `json:"dry_run"`
+	}
+
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		req.Crons = readFormFile(c, "crons")
+		req.Envs = readFormFile(c, "envs")
+		req.DryRun = c.PostForm("dry_run") == "true"
+	} else if err := c.ShouldBindJSON(&req); err != nil {
+		r.ErrMesage(c, "请求参数错误")
+		return
+	}
+	if len(req.Crons) == 0 && len(req.Envs) == 0 {
+		r.ErrMesage(c, "导入内容不能为空")
+		return
+	}
+
+	failed := []unconverted{}
+
+	// 转换定时任务
+	cfg, err := config.ReadConfigFileToJson()
+	if err != nil {
+		r.ErrMesage(c, "读取配置文件失败")
+		return
+	}
+	tasks, taskFailed := convertQinglongCrons(qinglongList(req.Crons))
+	failed = append(failed, taskFailed...)
+	plan, configStr := planImport(cfg, tasks)
+
+	// 转换环境变量
+	envs, envFailed := convertQinglongEnvs(qinglongList(req.Envs))
+	failed = append(failed, envFailed...)
+	envResult, _, err := planEnvIni(envs)
+	if err != nil {
+		r.ErrMesage(c, "读取env.ini失败")
+		return
+	}
+
+	result := gin.H{
+		"tasks":       plan,
+		"envs":        envResult,
+		"unconverted": failed,
+	}
+	if req.DryRun {
+		r.OkMesageData(c, "导入预览", result)
+		return
+	}
+
+	if len(plan.Add)+len(plan.Update) > 0 {
+		err := config.UpdateConfig(c.GetString(r.UserKey), func(cfg gjson.Result) ([]byte, error) {
+			// 基于最新配置重新合并,避免覆盖期间的其他修改
+			plan, configStr = planImport(cfg, tasks)
+			return []byte(configStr), nil
+		})
+		if err != nil {
+			r.ErrMesage(c, "导入失败,配置文件写入失败")
+			return
+		}
+		result["tasks"] = plan
+		if cfg, err := config.ReadConfigFileToJson(); err == nil {
+			mycron.ReloadTasks(cfg)
+		}
+	}
+	if len(envResult.Add)+len(envResult.Update) > 0 {
+		envResult, err = mergeEnvIni(envs)
+		if err != nil {
+			r.ErrMesage(c, "导入失败,env.ini写入失败")
+			return
+		}
+		result["envs"] = envResult
+	}
+
+	r.OkMesageData(c, "导入完成", result)
+}
+
+// 读取上传的文件内容
+func readFormFile(c *gin.Context, field string) json.RawMessage {
+	file, err := c.FormFile(field)
+	if err != nil {
+		return nil
+	}
+	f, err := file.Open()
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	content, _ := io.ReadAll(io.LimitReader(f, 10*1024*1024))
+	return content
+}
+
+// 青龙导出和接口返回的几种格式: [...] / {"data": [...]} / {"data": {"data": [...]}}
+func qinglongList(raw json.RawMessage) []gjson.Result {
+	data := gjson.ParseBytes(raw)
+	for i := 0; i < 2 && data.IsObject(); i++ {
+		data = data.Get("data")
+	}
+	return data.Array()
+}
+
+// 转换青龙定时任务
+func convertQinglongCrons(list []gjson.Result) ([]transferTask, []unconverted) {
+	var tasks []transferTask
+	var failed []unconverted
+	names := map[string]bool{}
+
+	for i, item := range list {
+		name := strings.TrimSpace(item.Get("name").String())
+		command := strings.TrimSpace(item.Get("command").String())
+		schedule := strings.TrimSpace(item.Get("schedule").String())
+
+		workdir, exec, err := convertQinglongCommand(command)
+		if err != nil {
+			failed = append(failed, unconverted{Name: name, Command: command, Reason: err.Error()})
+			continue
+		}
+
+		spec := schedule
+		if fields := strings.Fields(schedule); len(fields) == 5 {
+			spec = "0 " + strings.Join(fields, " ")
+		}
+		if _, err := mycron.Parser.Parse(spec); err != nil {
+			failed = append(failed, unconverted{Name: name, Command: command, Reason: "定时表达式错误: " + schedule})
+			continue
+		}
+
+		if name == "" {
+			name = nameFromCommand(exec, i+1)
+		}
+		if names[name] {
+			name = fmt.Sprintf("%s_%d", name, i+1)
+		}
+		names[name] = true
+
+		tasks = append(tasks, transferTask{
+			Name:    name,
+			Times:   []string{spec},
+			WorkDir: workdir,
+			Exec:    exec,
+			Enable:  item.Get("isDisabled").Int() == 0,
+		})
+	}
+	return tasks, failed
+}
+
+/*
+将青龙的 task 命令转为执行命令
+task dir/a.js now -> 工作目录 dir, 执行 node a.js
+不支持 ql 命令和 conc/desi 并发模式
+*/
+func convertQinglongCommand(command string) (string, string, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return "", "", fmt.Errorf("命令为空")
+	}
+	switch fields[0] {
+	case "ql":
+		return "", "", fmt.Errorf("不支持青龙内置命令")
+	case "task":
+	default:
+		// 普通shell命令直接使用
+		return "", command, nil
+	}
+
+	if len(fields) < 2 {
+		return "", "", fmt.Errorf("task命令缺少脚本")
+	}
+	script := strings.TrimPrefix(fields[1], "/ql/data/scripts/")
+	script = strings.TrimPrefix(script, "/ql/scripts/")
+
+	var args []string
+	for _, arg := range fields[2:] {
+		switch arg {
+		case "now":
+			// 青龙中表示跳过随机延迟,这里不需要
+			continue
+		case "conc", "desi":
+			return "", "", fmt.Errorf("不支持 %s 并发/指定账号模式", arg)
+		}
+		args = append(args, arg)
+	}
+
+	var interpreter string
+	switch path.Ext(script) {
+	case ".js", ".mjs", ".cjs":
+		interpreter = "node"
+	case ".py":
+		interpreter = "python3"
+		if config.IsWindows {
+			interpreter = "python"
+		}
+	case ".sh":
+		interpreter = "sh"
+	default:
+		return "", "", fmt.Errorf("不支持的脚本类型: %s", path.Ext(script))
+	}
+
+	workdir := path.Dir(script)
+	if workdir == "." {
+		workdir = ""
+	}
+	exec := strings.Join(append([]string{interpreter, path.Base(script)}, args...), " ")
+	return workdir, exec, nil
+}
+
+var envNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// 青龙环境变量
+type qinglongEnv struct {
+	Name    string
+	Value   string
+	Remarks string
+}
+
+// 转换青龙环境变量,同名变量按青龙的方式用&连接
+func convertQinglongEnvs(list []gjson.Result) ([]qinglongEnv, []unconverted) {
+	var envs []qinglongEnv
+	var failed []unconverted
+	index := map[string]int{}
+
+	for _, item := range list {
+		name := strings.TrimSpace(item.Get("name").String())
+		value := item.Get("value").String()
+		if name == "" || !envNameRegex.MatchString(name) {
+			failed = append(failed, unconverted{Name: name, Reason: "变量名无效"})
+			continue
+		}
+		if item.Get("status").Int() != 0 {
+			failed = append(failed, unconverted{Name: name, Reason: "变量已禁用"})
+			continue
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			failed = append(failed, unconverted{Name: name, Reason: "env.ini不支持多行的值"})
+			continue
+		}
+
+		if i, ok := index[name]; ok {
+			envs[i].Value += "&" + value
+			continue
+		}
+		index[name] = len(envs)
+		envs = append(envs, qinglongEnv{
+			Name:    name,
+			Value:   value,
+			Remarks: strings.TrimSpace(strings.ReplaceAll(item.Get("remarks").String(), "\n", " ")),
+		})
+	}
+	return envs, failed
+}
+
+var envLock sync.Mutex
+
+// 写入时重新读取env.ini再合并,只修改导入的变量,其他内容保持不变
+// 先写入临时文件再重命名,写入失败时原文件不受影响
+func mergeEnvIni(envs []qinglongEnv) (envPlan, error) {
+	envLock.Lock()
+	defer envLock.Unlock()
+
+	plan, content, err := planEnvIni(envs)
+	if err != nil {
+		return plan, err
+	}
+	envPath := pathutil.GetEnvPath()
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(envPath); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp := envPath + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), mode); err != nil {
+		return plan, err
+	}
+	if err := os.Rename(tmp, envPath); err != nil {
+		os.Remove(tmp)
+		return plan, err
+	}
+	return plan, nil
+}
+
+// 合并到env.ini,已有变量原位更新,新变量追加到末尾
+func planEnvIni(envs []qinglongEnv) (envPlan, string, error) {
+	plan := envPlan{Add: []string{}, Update: []string{}, Skip: []string{}}
+
+	var lines []string
+	existing := map[string]int{}
+	f, err := os.Open(pathutil.GetEnvPath())
+	if err != nil && !os.IsNotExist(err) {
+		return plan, "", err
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := scanner.Text()
+			trimmed := strings.TrimSpace(line)
+			if parts := strings.SplitN(trimmed, "=", 2); len(parts) == 2 &&
+				!strings.HasPrefix(trimmed, "#") && !strings.HasPrefix(trimmed, ";") {
+				existing[strings.TrimSpace(parts[0])] = len(lines)
+			}
+			lines = append(lines, line)
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return plan, "", err
+		}
+	}
+
+	for _, env := range envs {
+		line := env.Name + "=" + env.Value
+		if i, ok := existing[env.Name]; ok {
+			if strings.TrimSpace(lines[i]) == line {
+				plan.Skip = append(plan.Skip, env.Name)
+				continue
+			}
+			lines[i] = line
+			plan.Update = append(plan.Update, env.Name)
+			continue
+		}
+		if env.Remarks != "" {
+			lines = append(lines, "# "+env.Remarks)
+		}
+		existing[env.Name] = len(lines)
+		lines = append(lines, line)
+		plan.Add = append(plan.Add, env.Name)
+	}
+
+	return plan, strings.Join(lines, "\n") + "\n", nil
+}
@@ -2,20 +2,18 @@ package cron
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"os"
 	"strconv"
 	"xuanwu/config"
 	r "xuanwu/gin/response"
-	"xuanwu/lib/pathutil"
 	mycron "xuanwu/xuanwu"
 	xwlog "xuanwu/log"
 
 	"github.com/gin-gonic/gin"
 	"github.com/tidwall/gjson"
-	"github.com/robfig/cron/v3"
 )
 
 // TaskInfo 完整的任务信息结构
@@ -44,10 +42,7 @@ func HandlerTaskList(c *gin.Context) {
 	tasks := cfg.Get("task")
 
 	// 获取所有运行中任务的映射
-	runningTasks := make(map[string]cron.EntryID)
-	for id, task := range mycron.TaskData {
-		runningTasks[task.Name] = id
-	}
+	runningTasks := mycron.RunningTasks()
 
 	// 遍历配置中的所有任务
 	tasks.ForEach(func(key, value gjson.Result) bool {
@@ -137,7 +132,7 @@ func HandlerExecuteTask(c *gin.Context) {
 				combinedLog := log.New(multiWriter, "", 0)
 
 				// 同步执行任务
-				execErr = mycron.ExecTask(value.Get("exec").String(), value.Get("workdir").String(), combinedLog)
+				execErr = mycron.RunTask(req.Name, value.Get("exec").String(), value.Get("workdir").String(), combinedLog, mycron.TRIGGER_MANUAL)
 				taskOutput = memLog.String()
 				return false
 			}
@@ -182,115 +177,96 @@ func HandlerExecuteTask(c *gin.Context) {
 	r.OkData(c, response)
 }
 
+/* 获取任务运行记录 */
+func HandlerRunList(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	list, err := config.GetStore().ListRuns(config.RunQuery{
+		Name:  c.Query("name"),
+		Limit: limit,
+	})
+	if err != nil {
+		r.ErrMesage(c, "读取运行记录失败")
+		return
+	}
+	r.OkData(c, list)
+}
+
 /* 启用任务 */
 func HandlerEnableTask(c *gin.Context) {
-	name := c.Query("name")
+	name := bindTaskName(c)
 	if name == "" {
 		r.ErrMesage(c, "任务名称不能为空")
 		return
 	}
 
-	cfg, err := config.ReadConfigFileToJson()
+	// 查找并更新任务状态
+	task, err := setTaskEnable(c.GetString(r.UserKey), name, true)
+	if errors.Is(err, errTaskNotFound) {
+		r.ErrMesage(c, "任务不存在")
+		return
+	}
 	if err != nil {
-		log.Println("读取配置文件出错")
+		r.ErrMesage(c, "启用失败,配置文件写入失败")
 		return
 	}
 
-	// 查找并更新任务状态
-	found := false
-	result := cfg.Get("task")
-	for i, task := range result.Array() {
-		if task.Get("name").String() == name {
-			found = true
-			// 更新配置文件
-			jp := &JsonParams{data: cfg.Raw}
-			jp.Set(fmt.Sprintf("task.%v.enable", i), true)
-			configPath := pathutil.GetConfigPath()
-			err := config.WriteConfigFile(configPath, []byte(jp.data))
-			if err != nil {
-				r.ErrMesage(c, "启用失败,配置文件写入失败")
-				return
-			}
-
-			// 添加到cron
-			TaskData := mycron.TaskInfo{
-				Name: task.Get("name").String(),
-				Times: func() []string {
-					var times []string
-					for _, t := range task.Get("times").Array() {
-						times = append(times, t.String())
-					}
-					return times
-				}(),
-				WorkDir: task.Get("workdir").String(),
-				Exec:    task.Get("exec").String(),
-				Enable:  true,
+	// 添加到cron
+	TaskData := mycron.TaskInfo{
+		Name: task.Get("name").String(),
+		Times: func() []string {
+			var times []string
+			for _, t := range task.Get("times").Array() {
+				times = append(times, t.String())
 			}
-			mycron.AddRunFunc(TaskData)
-			break
-		}
-	}
-
-	if !found {
-		r.ErrMesage(c, "任务不存在")
-		return
+			return times
+		}(),
+		WorkDir: task.Get("workdir").String(),
+		Exec:    task.Get("exec").String(),
+		Enable:  true,
 	}
+	mycron.AddRunFunc(TaskData)
 
 	r.OkMesage(c, "启用成功")
 }
 
+// 在保存锁内修改任务的启用状态,返回修改前的任务配置
+func setTaskEnable(user, name string, enable bool) (gjson.Result, error) {
+	var found gjson.Result
+	err := config.UpdateConfig(user, func(cfg gjson.Result) ([]byte, error) {
+		for i, task := range cfg.Get("task").Array() {
+			if task.Get("name").String() == name {
+				found = task
+				jp := &JsonParams{data: cfg.Raw}
+				jp.Set(fmt.Sprintf("task.%v.enable", i), enable)
+				return []byte(jp.data), nil
+			}
+		}
+		return nil, errTaskNotFound
+	})
+	return found, err
+}
+
 /* 禁用任务 */
 func HandlerDisableTask(c *gin.Context) {
-	name := c.Query("name")
+	name := bindTaskName(c)
 	if name == "" {
 		r.ErrMesage(c, "任务名称不能为空")
 		return
 	}
 
-	cfg, err := config.ReadConfigFileToJson()
-	if err != nil {
-		log.Println("读取配置文件出错")
-		return
-	}
-
 	// 查找并更新任务状态
-	found := false
-	result := cfg.Get("task")
-	for i, task := range result.Array() {
-		if task.Get("name").String() == name {
-			found = true
-			// 更新配置文件
-			jp := &JsonParams{data: cfg.Raw}
-			jp.Set(fmt.Sprintf("task.%v.enable", i), false)
-			configPath := pathutil.GetConfigPath()
-			err := config.WriteConfigFile(configPath, []byte(jp.data))
-			if err != nil {
-				r.ErrMesage(c, "禁用失败,配置文件写入失败")
-				return
-			}
-
-			// 从cron中移除任务
-			for _, e := range mycron.C.Entries() {
-				if taskInfo, exists := mycron.TaskData[e.ID]; exists && taskInfo.Name == name {
-					mycron.C.Remove(e.ID)
-					// 关闭日志文件
-					if file, ok := taskInfo.Writer.(*os.File); ok {
-						file.Close()
-					}
-					// 停止写入日志
-					taskInfo.Log.SetOutput(io.Discard)
-					// 从映射表中删除
-					delete(mycron.TaskData, e.ID)
-				}
-			}
-			break
-		}
-	}
-
-	if !found {
+	_, err := setTaskEnable(c.GetString(r.UserKey), name, false)
+	if errors.Is(err, errTaskNotFound) {
 		r.ErrMesage(c, "任务不存在")
 		return
 	}
+	if err != nil {
+		r.ErrMesage(c, "禁用失败,配置文件写入失败")
+		return
+	}
+
+	// 从cron中移除任务
+	mycron.RemoveTask(name)
 
 	r.OkMesage(c, "禁用成功")
 }
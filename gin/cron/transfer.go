@@ -0,0 +1,454 @@
+package cron
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"xuanwu/config"
+	r "xuanwu/gin/response"
+	mycron "xuanwu/xuanwu"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"gopkg.in/yaml.v3"
+)
+
+// 导入导出的任务结构
+type transferTask struct {
+	Name    string   `json:"name" yaml:"name"`
+	Times   []string `json:"times" yaml:"times"`
+	WorkDir string   `json:"workdir" yaml:"workdir"`
+	Exec    string   `json:"exec" yaml:"exec"`
+	Enable  bool     `json:"enable" yaml:"enable"`
+}
+
+// 导入时无法处理的内容
+type importError struct {
+	Line  int    `json:"line,omitempty"` // crontab行号
+	Name  string `json:"name,omitempty"`
+	Error string `json:"error"`
+}
+
+// 导入预览结果
+type importPlan struct {
+	Add    []transferTask `json:"add"`
+	Update []transferTask `json:"update"`
+	Skip   []transferTask `json:"skip"`
+	Errors []importError  `json:"errors"`
+}
+
+// 从配置读取全部任务
+func loadTransferTasks(cfg gjson.Result) []transferTask {
+	var tasks []transferTask
+	cfg.Get("task").ForEach(func(key, value gjson.Result) bool {
+		task := transferTask{
+			Name:    value.Get("name").String(),
+			WorkDir: value.Get("workdir").String(),
+			Exec:    value.Get("exec").String(),
+			Enable:  value.Get("enable").Bool(),
+		}
+		for _, t := range value.Get("times").Array() {
+			task.Times = append(task.Times, t.String())
+		}
+		tasks = append(tasks, task)
+		return true
+	})
+	return tasks
+}
+
+/* 导出任务 format: json/yaml/crontab */
+func HandlerExportTask(c *gin.Context) {
+	cfg, err := config.ReadConfigFileToJson()
+	if err != nil {
+		r.ErrMesage(c, "读取配置文件失败")
+		return
+	}
+	tasks := loadTransferTasks(cfg)
+
+	var content []byte
+	format := c.DefaultQuery("format", "json")
+	switch format {
+	case "json":
+		content, err = json.MarshalIndent(gin.H{"tasks": tasks}, "", "    ")
+	case "yaml", "yml":
+		format = "yaml"
+		content, err = yaml.Marshal(map[string]interface{}{"tasks": tasks})
+	case "crontab":
+		content = []byte(formatCrontab(tasks))
+	default:
+		r.ErrMesage(c, "不支持的导出格式")
+		return
+	}
+	if err != nil {
+		r.ErrMesage(c, "导出失败")
+		return
+	}
+
+	fileName := "xuanwu_tasks." + format
+	if format == "crontab" {
+		fileName = "xuanwu.crontab"
+	}
+	c.Header("Content-Disposition", "attachment; filename="+fileName)
+	c.Data(200, "application/octet-stream", content)
+}
+
+// 生成crontab文件,任务名和工作目录写在注释中,禁用的任务注释掉定时行
+func formatCrontab(tasks []transferTask) string {
+	var buf bytes.Buffer
+	buf.WriteString("# 玄武任务导出\n")
+	for _, task := range tasks {
+		buf.WriteString("\n# name: " + task.Name + "\n")
+		if task.WorkDir != "" {
+			buf.WriteString("# workdir: " + task.WorkDir + "\n")
+		}
+		for _, spec := range task.Times {
+			if !task.Enable {
+				buf.WriteString("# ")
+			}
+			buf.WriteString(toCrontabSpec(spec) + " " + task.Exec + "\n")
+		}
+	}
+	return buf.String()
+}
+
+// 秒字段为0时去掉,转为标准5字段
+func toCrontabSpec(spec string) string {
+	fields := strings.Fields(spec)
+	if len(fields) == 6 && fields[0] == "0" {
+		return strings.Join(fields[1:], " ")
+	}
+	return spec
+}
+
+/*
+导入任务 format: json/yaml/crontab
+dry_run为true时只返回预览,不写入配置
+*/
+func HandlerImportTask(c *gin.Context) {
+	var req struct {
+		Format  string `json:"format" form:"format"`
+		Content string `json:"content" form:"content"`
+		DryRun  bool   `json:"dry_run" form:"dry_run"`
+	}
+	if err := c.ShouldBind(&req); err != nil {
+		r.ErrMesage(c, "请求参数错误")
+		return
+	}
+
+	// 也可以直接上传文件
+	if file, err := c.FormFile("file"); err == nil {
+		f, err := file.Open()
+		if err != nil {
+			r.ErrMesage(c, "读取上传文件失败")
+			return
+		}
+		content, _ := io.ReadAll(io.LimitReader(f, 10*1024*1024))
+		f.Close()
+		req.Content = string(content)
+		if req.Format == "" {
+			req.Format = formatFromFileName(file.Filename)
+		}
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		r.ErrMesage(c, "导入内容不能为空")
+		return
+	}
+
+	var tasks []transferTask
+	var errs []importError
+	switch req.Format {
+	case "json":
+		tasks, errs = parseJSONTasks(req.Content)
+	case "yaml", "yml":
+		tasks, errs = parseYAMLTasks(req.Content)
+	case "crontab", "":
+		tasks, errs = parseCrontab(req.Content)
+	default:
+		r.ErrMesage(c, "不支持的导入格式")
+		return
+	}
+
+	cfg, err := config.ReadConfigFileToJson()
+	if err != nil {
+		r.ErrMesage(c, "读取配置文件失败")
+		return
+	}
+	plan, _ := planImport(cfg, tasks)
+	plan.Errors = append(append([]importError{}, errs...), plan.Errors...)
+
+	if req.DryRun || len(plan.Add)+len(plan.Update) == 0 {
+		r.OkMesageData(c, "导入预览", plan)
+		return
+	}
+
+	err = config.UpdateConfig(c.GetString(r.UserKey), func(cfg gjson.Result) ([]byte, error) {
+		// 基于最新配置重新合并,避免覆盖期间的其他修改
+		var configStr string
+		plan, configStr = planImport(cfg, tasks)
+		plan.Errors = append(append([]importError{}, errs...), plan.Errors...)
+		return []byte(configStr), nil
+	})
+	if err != nil {
+		r.ErrMesage(c, "导入失败,配置文件写入失败")
+		return
+	}
+	if cfg, err := config.ReadConfigFileToJson(); err == nil {
+		mycron.ReloadTasks(cfg)
+	}
+
+	r.OkMesageData(c, "导入完成", plan)
+}
+
+func formatFromFileName(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		return "json"
+	case ".yaml", ".yml":
+		return "yaml"
+	}
+	return "crontab"
+}
+
+// 对比现有任务,生成导入计划和导入后的配置
+func planImport(cfg gjson.Result, tasks []transferTask) (importPlan, string) {
+	plan := importPlan{
+		Add:    []transferTask{},
+		Update: []transferTask{},
+		Skip:   []transferTask{},
+		Errors: []importError{},
+	}
+	configStr := cfg.Raw
+	existing := loadTransferTasks(cfg)
+
+	for _, task := range tasks {
+		if err := validateTask(task); err != nil {
+			plan.Errors = append(plan.Errors, importError{Name: task.Name, Error: err.Error()})
+			continue
+		}
+
+		index := -1
+		for i, old := range existing {
+			if old.Name == task.Name {
+				index = i
+				break
+			}
+		}
+
+		switch {
+		case index < 0:
+			plan.Add = append(plan.Add, task)
+			configStr, _ = sjson.Set(configStr, "task.-1", task)
+			existing = append(existing, task)
+		case sameTask(existing[index], task):
+			plan.Skip = append(plan.Skip, task)
+		default:
+			plan.Update = append(plan.Update, task)
+			configStr, _ = sjson.Set(configStr, fmt.Sprintf("task.%d", index), task)
+			existing[index] = task
+		}
+	}
+	return plan, configStr
+}
+
+func validateTask(task transferTask) error {
+	if task.Name == "" {
+		return fmt.Errorf("任务名称不能为空")
+	}
+	if task.Exec == "" {
+		return fmt.Errorf("执行命令不能为空")
+	}
+	if len(task.Times) == 0 {
+		return fmt.Errorf("定时表达式不能为空")
+	}
+	for _, spec := range task.Times {
+		if _, err := mycron.Parser.Parse(spec); err != nil {
+			return fmt.Errorf("定时表达式错误[%s]: %v", spec, err)
+		}
+	}
+	return nil
+}
+
+func sameTask(a, b transferTask) bool {
+	return a.Name == b.Name && a.WorkDir == b.WorkDir && a.Exec == b.Exec &&
+		a.Enable == b.Enable && strings.Join(a.Times, "\n") == strings.Join(b.Times, "\n")
+}
+
+// 支持 {"tasks": [...]} 和直接的任务数组
+func parseJSONTasks(content string) ([]transferTask, []importError) {
+	data := gjson.Parse(content)
+	if data.IsObject() {
+		data = data.Get("tasks")
+	}
+	if !data.IsArray() {
+		return nil, []importError{{Error: "json格式错误,需要任务数组"}}
+	}
+	var tasks []transferTask
+	if err := json.Unmarshal([]byte(data.Raw), &tasks); err != nil {
+		return nil, []importError{{Error: "json格式错误: " + err.Error()}}
+	}
+	return tasks, nil
+}
+
+func parseYAMLTasks(content string) ([]transferTask, []importError) {
+	var doc struct {
+		Tasks []transferTask `yaml:"tasks"`
+	}
+	if err := yaml.Unmarshal([]byte(content), &doc); err != nil || doc.Tasks == nil {
+		var list []transferTask
+		if err2 := yaml.Unmarshal([]byte(content), &list); err2 != nil {
+			if err == nil {
+				err = err2
+			}
+			return nil, []importError{{Error: "yaml格式错误: " + err.Error()}}
+		}
+		return list, nil
+	}
+	return doc.Tasks, nil
+}
+
+var (
+	envLineRegex   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*\s*=`)
+	metaLineRegex  = regexp.MustCompile(`^#\s*(name|workdir):\s*(.*)$`)
+	cronFieldRegex = regexp.MustCompile(`^[0-9A-Za-z*/,\-?LW#]+$`)
+)
+
+/*
+解析crontab文件
+标准5字段自动补充秒字段,6字段按带秒处理
+"# name:" "# workdir:" 注释指定下一条任务的名称和工作目录,紧跟其后被注释的定时行作为禁用的任务导入
+*/
+func parseCrontab(content string) ([]transferTask, []importError) {
+	var tasks []transferTask
+	var errs []importError
+	index := map[string]int{}
+	name, workdir := "", ""
+
+	for i, raw := range strings.Split(content, "\n") {
+		lineNo := i + 1
+		line := strings.TrimSpace(raw)
+		if line == "" {
+			name, workdir = "", ""
+			continue
+		}
+
+		enable := true
+		if strings.HasPrefix(line, "#") {
+			if m := metaLineRegex.FindStringSubmatch(line); m != nil {
+				if m[1] == "name" {
+					name = strings.TrimSpace(m[2])
+				} else {
+					workdir = strings.TrimSpace(m[2])
+				}
+				continue
+			}
+			// 只有带名称注释的被注释定时行才作为禁用任务
+			if name == "" {
+				continue
+			}
+			line = strings.TrimSpace(strings.TrimPrefix(line, "#"))
+			enable = false
+		}
+		if envLineRegex.MatchString(line) {
+			errs = append(errs, importError{Line: lineNo, Error: "跳过环境变量行"})
+			continue
+		}
+
+		spec, command, err := splitCrontabLine(line)
+		if err != nil {
+			if enable {
+				errs = append(errs, importError{Line: lineNo, Error: err.Error()})
+			}
+			continue
+		}
+
+		taskName := name
+		if taskName == "" {
+			taskName = nameFromCommand(command, len(tasks)+1)
+		}
+		// 同名同命令的多行合并为多个定时
+		if idx, ok := index[taskName]; ok && tasks[idx].Exec == command {
+			tasks[idx].Times = append(tasks[idx].Times, spec)
+			continue
+		} else if ok {
+			taskName = fmt.Sprintf("%s_%d", taskName, lineNo)
+		}
+
+		index[taskName] = len(tasks)
+		tasks = append(tasks, transferTask{
+			Name:    taskName,
+			Times:   []string{spec},
+			WorkDir: workdir,
+			Exec:    command,
+			Enable:  enable,
+		})
+	}
+	return tasks, errs
+}
+
+// 拆分定时表达式和命令
+func splitCrontabLine(line string) (string, string, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", "", fmt.Errorf("空行")
+	}
+
+	if strings.HasPrefix(fields[0], "@") {
+		if fields[0] == "@reboot" {
+			return "", "", fmt.Errorf("不支持 @reboot")
+		}
+		if len(fields) < 2 {
+			return "", "", fmt.Errorf("缺少执行命令")
+		}
+		if _, err := mycron.Parser.Parse(fields[0]); err != nil {
+			return "", "", fmt.Errorf("定时表达式错误: %v", err)
+		}
+		return fields[0], commandAfter(line, 1), nil
+	}
+
+	// 第6个字段也像定时字段且能解析时按带秒的6字段处理
+	if len(fields) >= 7 && cronFieldRegex.MatchString(fields[5]) {
+		spec := strings.Join(fields[:6], " ")
+		if _, err := mycron.Parser.Parse(spec); err == nil {
+			return spec, commandAfter(line, 6), nil
+		}
+	}
+
+	if len(fields) < 6 {
+		return "", "", fmt.Errorf("格式错误,需要5个定时字段和执行命令")
+	}
+	spec := "0 " + strings.Join(fields[:5], " ")
+	if _, err := mycron.Parser.Parse(spec); err != nil {
+		return "", "", fmt.Errorf("定时表达式错误: %v", err)
+	}
+	return spec, commandAfter(line, 5), nil
+}
+
+// 取第n个字段之后的原始命令,保留命令中的空白
+func commandAfter(line string, n int) string {
+	rest := line
+	for i := 0; i < n; i++ {
+		rest = strings.TrimLeft(rest, " \t")
+		if idx := strings.IndexAny(rest, " \t"); idx >= 0 {
+			rest = rest[idx:]
+		} else {
+			rest = ""
+		}
+	}
+	return strings.TrimSpace(rest)
+}
+
+// 根据命令生成任务名,优先使用脚本文件名
+func nameFromCommand(command string, n int) string {
+	for _, field := range strings.Fields(command) {
+		base := filepath.Base(field)
+		if ext := filepath.Ext(base); ext != "" && len(ext) <= 4 {
+			return strings.TrimSuffix(base, ext)
+		}
+	}
+	return fmt.Sprintf("cron_%d", n)
+}
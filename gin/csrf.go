@@ -0,0 +1,57 @@
+package serve
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"xuanwu/lib"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CSRF令牌使用双重提交cookie校验: 登录时写入可被前端读取的cookie,
+// 使用cookie登录的修改请求需要在请求头中带上相同的值
+const (
+	csrfCookieName = "csrf_token"
+	csrfHeaderName = "X-CSRF-Token"
+)
+
+// 设置CSRF令牌cookie并返回令牌
+func setCSRFCookie(c *gin.Context, maxAge int) string {
+	token := lib.RandomString(16)
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(csrfCookieName, token, maxAge, cookiePath(), "", tlsEnabled, false)
+	return token
+}
+
+// 清除CSRF令牌cookie
+func clearCSRFCookie(c *gin.Context) {
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(csrfCookieName, "", -1, cookiePath(), "", tlsEnabled, false)
+}
+
+// 是否为不修改数据的请求方法
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// 校验CSRF令牌,cookie中没有令牌时补发一个,前端读取后重试即可
+func checkCSRF(c *gin.Context) bool {
+	cookie, err := c.Cookie(csrfCookieName)
+	if err != nil || cookie == "" {
+		setCSRFCookie(c, GetCookieExpireDays()*24*60*60)
+		return isSafeMethod(c.Request.Method)
+	}
+	if isSafeMethod(c.Request.Method) {
+		return true
+	}
+	header := c.GetHeader(csrfHeaderName)
+	return header != "" && subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) == 1
+}
+
+// CSRF令牌校验失败
+func abortCSRF(c *gin.Context) {
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"code":    403,
+		"message": "CSRF令牌校验失败,请刷新页面后重试",
+	})
+}
@@ -4,10 +4,13 @@ import (
 	"io"
 	"math"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
+	"xuanwu/config"
 	"xuanwu/gin/response"
+	"xuanwu/lib/backup"
 	"xuanwu/lib/pathutil"
 
 	"github.com/gin-gonic/gin"
@@ -21,13 +24,70 @@ type FileInfo struct {
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
-// 验证文件路径是否在DATA_DIR下，返回完整路径，如果不合法返回空字符串
-func validatePath(subPath string) string {
+// 数据目录中只有管理员可以访问的文件和目录,按第一级名称匹配
+var sensitivePatterns = []string{
+	pathutil.SECRET_FILE,
+	pathutil.CONFIG_FILE + "*", // 包括迁移备份 config.json.bak
+	config.API_TOKEN_FILE,
+	config.SESSION_FILE,
+	config.SQLITE_FILE + "*", // 包括 -wal、-shm 文件
+	config.REVISION_FILE,
+	config.AUDIT_FILE,
+	pathutil.TLS_DIR,
+	backup.BACKUP_DIR,
+	pathutil.UI_DIR,
+}
+
+// 是否为保存密钥、会话、配置等敏感信息的路径
+func isSensitivePath(fullPath string) bool {
+	rel, err := filepath.Rel(pathutil.GetDataDir(), fullPath)
+	if err != nil || rel == "." {
+		return false
+	}
+	// 部分系统的文件名不区分大小写,统一按小写比较
+	top := strings.ToLower(strings.SplitN(filepath.ToSlash(rel), "/", 2)[0])
+	for _, pattern := range sensitivePatterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), top); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// 验证文件路径是否在数据目录下，返回完整路径，如果不合法返回空字符串
+// 敏感文件只有管理员可以访问
+func validatePath(c *gin.Context, subPath string) string {
 	fullPath := pathutil.GetDataPath(subPath)
-	if !strings.HasPrefix(fullPath, filepath.Join(pathutil.GetRootDir(), pathutil.DATA_DIR)) {
+	if !pathutil.IsInDataDir(fullPath) {
+		return ""
+	}
+	fullPath = filepath.Clean(fullPath)
+	if isSensitivePath(fullPath) && !HasPermission(c, PERM_ADMIN) {
 		return ""
 	}
-	return filepath.Clean(fullPath)
+	return fullPath
+}
+
+// 验证读取路径,只有日志权限时限制在日志目录内
+func validateReadPath(c *gin.Context, subPath string) string {
+	fullPath := validatePath(c, subPath)
+	if fullPath == "" || HasPermission(c, PERM_FILE_READ) {
+		return fullPath
+	}
+	logDir := pathutil.GetDataPath(pathutil.LOG_DIR)
+	if rel, err := filepath.Rel(logDir, fullPath); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ""
+	}
+	return fullPath
+}
+
+// 验证删除、重命名的路径,不允许操作数据目录本身
+func validateModifyPath(c *gin.Context, subPath string) string {
+	fullPath := validatePath(c, subPath)
+	if fullPath == filepath.Clean(pathutil.GetDataDir()) {
+		return ""
+	}
+	return fullPath
 }
 
 // 获取文件列表
@@ -37,7 +97,7 @@ func HandlerFileList(c *gin.Context) {
 		path = "."
 	}
 
-	fullPath := validatePath(path)
+	fullPath := validateReadPath(c, path)
 	if fullPath == "" {
 		response.ErrMesage(c, "非法路径")
 		return
@@ -56,7 +116,11 @@ func HandlerFileList(c *gin.Context) {
 			continue
 		}
 
-		relativePath, err := filepath.Rel(filepath.Join(pathutil.GetRootDir(), pathutil.DATA_DIR), filepath.Join(fullPath, f.Name()))
+		entryPath := filepath.Join(fullPath, f.Name())
+		if isSensitivePath(entryPath) && !HasPermission(c, PERM_ADMIN) {
+			continue
+		}
+		relativePath, err := filepath.Rel(pathutil.GetDataDir(), entryPath)
 		if err != nil {
 			continue
 		}
@@ -79,7 +143,7 @@ func HandlerFileUpload(c *gin.Context) {
 		path = "."
 	}
 
-	fullPath := validatePath(path)
+	fullPath := validatePath(c, path)
 	if fullPath == "" {
 		response.ErrMesage(c, "非法路径")
 		return
@@ -97,6 +161,10 @@ func HandlerFileUpload(c *gin.Context) {
 	}
 
 	dst := filepath.Join(fullPath, file.Filename)
+	if isSensitivePath(dst) && !HasPermission(c, PERM_ADMIN) {
+		response.ErrMesage(c, "非法路径")
+		return
+	}
 	if err := c.SaveUploadedFile(file, dst); err != nil {
 		response.ErrMesage(c, "保存文件失败")
 		return
@@ -113,7 +181,7 @@ func HandlerFileDownload(c *gin.Context) {
 		return
 	}
 
-	fullPath := validatePath(path)
+	fullPath := validateReadPath(c, path)
 	if fullPath == "" {
 		response.ErrMesage(c, "非法路径")
 		return
@@ -167,7 +235,7 @@ func HandlerFileContent(c *gin.Context) {
 		return
 	}
 
-	fullPath := validatePath(path)
+	fullPath := validateReadPath(c, path)
 	if fullPath == "" {
 		response.ErrMesage(c, "非法路径")
 		return
@@ -216,7 +284,7 @@ func HandlerFileEdit(c *gin.Context) {
 		return
 	}
 
-	fullPath := validatePath(req.Path)
+	fullPath := validatePath(c, req.Path)
 	if fullPath == "" {
 		response.ErrMesage(c, "非法路径")
 		return
@@ -244,7 +312,7 @@ func HandlerFileDelete(c *gin.Context) {
 		return
 	}
 
-	fullPath := validatePath(path)
+	fullPath := validateModifyPath(c, path)
 	if fullPath == "" {
 		response.ErrMesage(c, "非法路径")
 		return
@@ -271,7 +339,7 @@ func HandlerBatchUpload(c *gin.Context) {
 		path = "."
 	}
 
-	fullPath := validatePath(path)
+	fullPath := validatePath(c, path)
 	if fullPath == "" {
 		response.ErrMesage(c, "非法路径")
 		return
@@ -301,6 +369,13 @@ func HandlerBatchUpload(c *gin.Context) {
 
 	for _, file := range files {
 		dst := filepath.Join(fullPath, file.Filename)
+		if isSensitivePath(dst) && !HasPermission(c, PERM_ADMIN) {
+			results.Failed = append(results.Failed, UploadResult{
+				Name:  file.Filename,
+				Error: "非法路径",
+			})
+			continue
+		}
 		if err := c.SaveUploadedFile(file, dst); err != nil {
 			results.Failed = append(results.Failed, UploadResult{
 				Name:  file.Filename,
@@ -332,14 +407,14 @@ func HandlerFileRename(c *gin.Context) {
     }
 
     // 验证原路径
-    oldFullPath := validatePath(req.Path)
+    oldFullPath := validateModifyPath(c, req.Path)
     if oldFullPath == "" {
         response.ErrMesage(c, "原路径非法")
         return
     }
 
     // 验证新路径
-    newFullPath := validatePath(req.NewPath)
+    newFullPath := validateModifyPath(c, req.NewPath)
     if newFullPath == "" {
         response.ErrMesage(c, "新路径非法")
         return
@@ -382,7 +457,7 @@ func HandlerMkdir(c *gin.Context) {
 		return
 	}
 
-	fullPath := validatePath(req.Path)
+	fullPath := validatePath(c, req.Path)
 	if fullPath == "" {
 		response.ErrMesage(c, "非法路径")
 		return
@@ -0,0 +1,77 @@
+package serve
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const gzipMinSize = 1 << 10 // 小于该大小的响应不压缩
+
+var gzipPool = sync.Pool{New: func() any {
+	gz, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+	return gz
+}}
+
+// 在第一次写入时决定是否压缩,只压缩json响应
+type gzipWriter struct {
+	gin.ResponseWriter
+	gz      *gzip.Writer
+	decided bool
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		w.decided = true
+		h := w.Header()
+		if len(b) >= gzipMinSize && h.Get("Content-Encoding") == "" &&
+			strings.HasPrefix(h.Get("Content-Type"), "application/json") {
+			h.Set("Content-Encoding", "gzip")
+			h.Add("Vary", "Accept-Encoding")
+			h.Del("Content-Length")
+			w.gz = gzipPool.Get().(*gzip.Writer)
+			w.gz.Reset(w.ResponseWriter)
+		}
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *gzipWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// 结束压缩并放回池中
+func (w *gzipWriter) close() {
+	if w.gz != nil {
+		w.gz.Close()
+		gzipPool.Put(w.gz)
+		w.gz = nil
+	}
+}
+
+// GzipHandler 压缩接口返回的json,文件下载等其他类型不处理
+func GzipHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodHead || !acceptsEncoding(c.Request, "gzip") {
+			c.Next()
+			return
+		}
+		w := &gzipWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		defer w.close()
+		c.Next()
+	}
+}
@@ -0,0 +1,136 @@
+package serve
+
+import (
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+	"xuanwu/config"
+	"xuanwu/lib/pathutil"
+	"xuanwu/xuanwu"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	VERSION_NONE    = "none"    // 不提供版本接口
+	VERSION_VERSION = "version" // 只返回版本号
+	VERSION_FULL    = "full"    // 返回版本号、提交、编译信息
+
+	schedulerTimeout = 2 * time.Second
+)
+
+// 探针接口,不需要登录,设置访问路径前缀后在根路径同样可用
+var probePaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+	"/version": true,
+}
+
+// 探针返回信息的配置
+type healthConfig struct {
+	details bool   // 返回每项检查的结果
+	version string // 版本接口返回的内容
+}
+
+var (
+	healthCfg atomic.Pointer[healthConfig] // 重新加载配置时整体替换
+	startTime = time.Now()
+)
+
+func init() {
+	healthCfg.Store(&healthConfig{version: VERSION_VERSION})
+}
+
+// 读取探针配置
+func loadHealth(cfg gjson.Result) {
+	next := healthConfig{
+		details: cfg.Get("health.details").Bool(),
+		version: cfg.Get("health.version").String(),
+	}
+	switch next.version {
+	case VERSION_NONE, VERSION_FULL:
+	default:
+		next.version = VERSION_VERSION
+	}
+	healthCfg.Store(&next)
+}
+
+// 探针结果不能缓存
+func probeResponse(c *gin.Context, code int, data any) {
+	c.Header("Cache-Control", "no-store")
+	if c.Request.Method == http.MethodHead {
+		c.Status(code)
+		return
+	}
+	c.JSON(code, data)
+}
+
+// HandlerHealthz 进程存活
+func HandlerHealthz(c *gin.Context) {
+	data := gin.H{"status": "ok"}
+	if healthCfg.Load().details {
+		data["uptime"] = int64(time.Since(startTime).Seconds())
+	}
+	probeResponse(c, http.StatusOK, data)
+}
+
+// 检查数据目录是否可写
+func checkDataDir() error {
+	f, err := os.CreateTemp(pathutil.GetDataDir(), ".readyz-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// HandlerReadyz 检查配置可读、调度器运行、数据目录可写,全部通过才返回200
+func HandlerReadyz(c *gin.Context) {
+	checks := gin.H{}
+	ok := true
+	fail := func(name string, err string) {
+		checks[name] = err
+		ok = false
+	}
+
+	if cfg, err := config.ReadConfigFileToJson(); err != nil {
+		fail("config", err.Error())
+	} else if !cfg.IsObject() {
+		fail("config", "配置文件格式错误")
+	} else {
+		checks["config"] = "ok"
+	}
+	if xuanwu.SchedulerAlive(schedulerTimeout) {
+		checks["scheduler"] = "ok"
+	} else {
+		fail("scheduler", "调度器未运行")
+	}
+	if err := checkDataDir(); err != nil {
+		fail("data_dir", err.Error())
+	} else {
+		checks["data_dir"] = "ok"
+	}
+
+	code, data := http.StatusOK, gin.H{"status": "ok"}
+	if !ok {
+		code, data = http.StatusServiceUnavailable, gin.H{"status": "fail"}
+	}
+	if healthCfg.Load().details {
+		data["checks"] = checks
+	}
+	probeResponse(c, code, data)
+}
+
+// HandlerVersion 版本信息,返回内容由 health.version 控制
+func HandlerVersion(c *gin.Context) {
+	switch healthCfg.Load().version {
+	case VERSION_NONE:
+		c.Status(http.StatusNotFound)
+	case VERSION_FULL:
+		probeResponse(c, http.StatusOK, config.BuildInfo())
+	default:
+		probeResponse(c, http.StatusOK, gin.H{"version": config.Version})
+	}
+}
@@ -0,0 +1,256 @@
+package serve
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// 访问控制的范围,分别对应网页和接口
+const (
+	IP_SCOPE_WEB = "web"
+	IP_SCOPE_API = "api"
+)
+
+// 计算出的客户端IP通过该请求头交给gin,收到的同名请求头会被覆盖
+const clientIPHeader = "X-Xuanwu-Client-Ip"
+
+// UDS连接没有来源地址,没有转发请求头时使用的客户端IP
+const udsClientIP = "127.0.0.1"
+
+// 请求上下文中标记UDS连接的键
+type udsConnKey struct{}
+
+// IP访问规则
+type ipRule struct {
+	allow []*net.IPNet // 为空时允许全部
+	deny  []*net.IPNet
+}
+
+// 各范围的访问规则和可信代理,重新加载配置时一起替换
+var ipAccess = struct {
+	sync.RWMutex
+	rules   map[string]ipRule
+	proxies []*net.IPNet // trusted_proxies
+	headers []string     // 读取客户端IP的请求头
+}{rules: map[string]ipRule{}, headers: defaultRemoteIPHeaders}
+
+var defaultRemoteIPHeaders = []string{"X-Forwarded-For", "X-Real-IP"}
+
+// 解析IP或CIDR列表,单个IP视为/32或/128
+func parseIPNets(list []gjson.Result) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range list {
+		value := strings.TrimSpace(item.String())
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("无效的IP地址: %s", value)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("无效的CIDR: %s", value)
+		}
+		nets = append(nets, network)
+	}
+	return nets, nil
+}
+
+// ParseIPAccess 解析 ip_access 配置
+// 顶层的 allow/deny 对所有范围生效,web/api 中的 allow 会替换顶层的 allow,deny 则合并
+func ParseIPAccess(cfg gjson.Result) (map[string]ipRule, error) {
+	globalAllow, err := parseIPNets(cfg.Get("allow").Array())
+	if err != nil {
+		return nil, err
+	}
+	globalDeny, err := parseIPNets(cfg.Get("deny").Array())
+	if err != nil {
+		return nil, err
+	}
+
+	rules := map[string]ipRule{}
+	for _, scope := range []string{IP_SCOPE_WEB, IP_SCOPE_API} {
+		rule := ipRule{allow: globalAllow, deny: globalDeny}
+		section := cfg.Get(scope)
+		if allow := section.Get("allow"); allow.Exists() {
+			if rule.allow, err = parseIPNets(allow.Array()); err != nil {
+				return nil, err
+			}
+		}
+		deny, err := parseIPNets(section.Get("deny").Array())
+		if err != nil {
+			return nil, err
+		}
+		rule.deny = append(append([]*net.IPNet{}, globalDeny...), deny...)
+		rules[scope] = rule
+	}
+	return rules, nil
+}
+
+// 加载访问规则和可信代理,任意一项配置错误时都保留原设置
+func loadIPAccess(cfg gjson.Result) error {
+	rules, err := ParseIPAccess(cfg.Get("ip_access"))
+	if err != nil {
+		return err
+	}
+	proxies, err := parseIPNets(cfg.Get("trusted_proxies").Array())
+	if err != nil {
+		return fmt.Errorf("trusted_proxies: %v", err)
+	}
+	headers := defaultRemoteIPHeaders
+	if list := cfg.Get("remote_ip_headers").Array(); len(list) > 0 {
+		headers = nil
+		for _, h := range list {
+			headers = append(headers, h.String())
+		}
+	}
+
+	ipAccess.Lock()
+	ipAccess.rules = rules
+	ipAccess.proxies = proxies
+	ipAccess.headers = headers
+	ipAccess.Unlock()
+	return nil
+}
+
+// IP是否在任意一个网段中
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, network := range nets {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 是否允许访问,先检查拒绝列表
+func (rule ipRule) allowed(ip net.IP) bool {
+	for _, network := range rule.deny {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	if len(rule.allow) == 0 {
+		return true
+	}
+	for _, network := range rule.allow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 请求所属的访问范围
+func ipScope(path string) string {
+	if strings.HasPrefix(path, "/api") {
+		return IP_SCOPE_API
+	}
+	return IP_SCOPE_WEB
+}
+
+// IPAccessHandler 按来源IP限制访问
+func IPAccessHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scope := ipScope(c.Request.URL.Path)
+		ipAccess.RLock()
+		rule := ipAccess.rules[scope]
+		ipAccess.RUnlock()
+
+		if len(rule.allow) == 0 && len(rule.deny) == 0 {
+			c.Next()
+			return
+		}
+		if ip := net.ParseIP(c.ClientIP()); ip != nil && rule.allowed(ip) {
+			c.Next()
+			return
+		}
+		if scope == IP_SCOPE_WEB {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"code":    403,
+			"message": "当前IP不允许访问",
+		})
+	}
+}
+
+// 客户端IP由 ClientIPHandler 计算,不使用gin自带的可信代理判断
+func setTrustedProxies(engine *gin.Engine) error {
+	engine.TrustedPlatform = clientIPHeader
+	return engine.SetTrustedProxies(nil)
+}
+
+// ClientIPHandler 计算客户端IP,之后 c.ClientIP() 返回该值
+// 只有UDS连接和来自 trusted_proxies 的请求才使用转发请求头中的IP
+func ClientIPHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request.Header.Set(clientIPHeader, resolveClientIP(c.Request))
+		c.Next()
+	}
+}
+
+// 从直接连接的地址开始,沿转发请求头从右向左找到第一个不可信的IP
+func resolveClientIP(req *http.Request) string {
+	ipAccess.RLock()
+	proxies, headers := ipAccess.proxies, ipAccess.headers
+	ipAccess.RUnlock()
+	trusted := func(ip net.IP) bool {
+		return containsIP(proxies, ip)
+	}
+
+	peer := udsClientIP
+	if !isUDSRequest(req) {
+		host, _, err := net.SplitHostPort(strings.TrimSpace(req.RemoteAddr))
+		ip := net.ParseIP(host)
+		if err != nil || ip == nil {
+			return ""
+		}
+		peer = ip.String()
+		if !trusted(ip) {
+			return peer
+		}
+	}
+
+	for _, name := range headers {
+		items := strings.Split(req.Header.Get(name), ",")
+		for i := len(items) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(items[i]))
+			if ip == nil {
+				break
+			}
+			if i == 0 || !trusted(ip) {
+				return ip.String()
+			}
+		}
+	}
+	return peer
+}
+
+// 请求是否来自UDS连接
+func isUDSRequest(req *http.Request) bool {
+	uds, _ := req.Context().Value(udsConnKey{}).(bool)
+	return uds
+}
+
+// 标记来自UDS的请求,UDS只允许本机的反代连接,视为可信代理
+func udsHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := context.WithValue(req.Context(), udsConnKey{}, true)
+		handler.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
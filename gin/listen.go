@@ -0,0 +1,218 @@
+package serve
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"xuanwu/config"
+
+	"github.com/tidwall/gjson"
+)
+
+// UDS 默认权限,只允许所属用户和组访问
+const defaultUDSMode os.FileMode = 0660
+
+// 监听地址
+type listenAddr struct {
+	network string // tcp 或 unix
+	address string
+	mode    os.FileMode // 以下只用于UDS
+	owner   string
+	group   string
+}
+
+func (l listenAddr) String() string {
+	if l.network == "unix" {
+		return fmt.Sprintf("%s (权限: %04o)", l.address, l.mode)
+	}
+	return l.address
+}
+
+// ParseListen 读取监听地址
+// 优先级：环境变量 XW_PORT > 配置文件 listen > 配置文件 port > 默认值 4165
+// listen 中的每一项可以是端口、IP:端口、[IPv6]:端口、UDS路径,或带有 address、mode、owner、group 的对象
+// 顶层的 uds 设置所有UDS的默认 mode、owner、group
+func ParseListen(cfg gjson.Result, env string) ([]listenAddr, error) {
+	var items []gjson.Result
+	switch {
+	case env != "":
+		items = []gjson.Result{{Type: gjson.String, Str: env}}
+	case len(cfg.Get("listen").Array()) > 0:
+		items = cfg.Get("listen").Array()
+	case cfg.Get("port").String() != "":
+		items = []gjson.Result{cfg.Get("port")}
+	default:
+		items = []gjson.Result{{Type: gjson.String, Str: "4165"}}
+	}
+
+	defaults := cfg.Get("uds")
+	var list []listenAddr
+	for _, item := range items {
+		address := item.String()
+		opts := defaults
+		if item.IsObject() {
+			address = item.Get("address").String()
+			opts = item
+		}
+		l, err := parseListenAddr(address, opts, defaults)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, l)
+	}
+	return list, nil
+}
+
+// 解析单个地址,UDS的设置先取 opts 再取 defaults
+func parseListenAddr(address string, opts, defaults gjson.Result) (listenAddr, error) {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return listenAddr{}, fmt.Errorf("listen 地址不能为空")
+	}
+	if isUDSPath(address) {
+		if config.IsWindows {
+			return listenAddr{}, fmt.Errorf("Windows 不支持UDS: %s", address)
+		}
+		l := listenAddr{network: "unix", address: address, mode: defaultUDSMode}
+		get := func(key string) string {
+			if v := opts.Get(key); v.Exists() {
+				return v.String()
+			}
+			return defaults.Get(key).String()
+		}
+		if mode := get("mode"); mode != "" {
+			m, err := strconv.ParseUint(mode, 8, 32)
+			if err != nil || m > 0777 {
+				return listenAddr{}, fmt.Errorf("UDS权限格式错误: %s", mode)
+			}
+			l.mode = os.FileMode(m)
+		}
+		l.owner, l.group = get("owner"), get("group")
+		return l, nil
+	}
+
+	// 只有端口时监听所有地址
+	if _, err := strconv.Atoi(address); err == nil {
+		address = ":" + address
+	}
+	if _, port, err := net.SplitHostPort(address); err != nil || port == "" {
+		return listenAddr{}, fmt.Errorf("listen 地址格式错误: %s", address)
+	}
+	return listenAddr{network: "tcp", address: address}, nil
+}
+
+// 开始监听,UDS会删除旧文件并设置权限和所有者
+func (l listenAddr) listen() (net.Listener, error) {
+	if l.network != "unix" {
+		return net.Listen("tcp", l.address)
+	}
+
+	// 删除可能存在的旧socket文件
+	os.Remove(l.address)
+	listener, err := net.Listen("unix", l.address)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(l.address, l.mode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("设置UDS权限失败: %v", err)
+	}
+	if l.owner != "" || l.group != "" {
+		uid, gid, err := lookupOwner(l.owner, l.group)
+		if err == nil {
+			err = os.Chown(l.address, uid, gid)
+		}
+		if err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("设置UDS所有者失败: %v", err)
+		}
+	}
+	return listener, nil
+}
+
+// 查找用户和组,可以是名称或数字ID,为空时返回 -1 表示不修改
+func lookupOwner(owner, group string) (int, int, error) {
+	uid, gid := -1, -1
+	if owner != "" {
+		id := owner
+		if _, err := strconv.Atoi(owner); err != nil {
+			u, err := user.Lookup(owner)
+			if err != nil {
+				return 0, 0, err
+			}
+			id = u.Uid
+		}
+		uid, _ = strconv.Atoi(id)
+	}
+	if group != "" {
+		id := group
+		if _, err := strconv.Atoi(group); err != nil {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return 0, 0, err
+			}
+			id = g.Gid
+		}
+		gid, _ = strconv.Atoi(id)
+	}
+	return uid, gid, nil
+}
+
+// HTTPS 跳转使用的端口,取第一个TCP监听地址的端口
+func (p *ApiData) tlsPort() string {
+	for _, l := range p.Listen {
+		if l.network == "tcp" {
+			_, port, _ := net.SplitHostPort(l.address)
+			return port
+		}
+	}
+	return ""
+}
+
+// 在所有地址上提供服务,全部退出后返回
+func (p *ApiData) serveAll(handler http.Handler) {
+	var wg sync.WaitGroup
+	for _, l := range p.Listen {
+		listener, err := l.listen()
+		if err != nil {
+			fmt.Printf("监听 %s 失败: %v\n", l.address, err)
+			log.Printf("监听 %s 失败: %v", l.address, err)
+			continue
+		}
+
+		// UDS 只供本机反代连接,由反代处理HTTPS,始终使用HTTP
+		h := handler
+		kind := "端口"
+		useTLS := tlsEnabled
+		if l.network == "unix" {
+			h = udsHandler(handler)
+			kind = "UDS"
+			useTLS = false
+		}
+		if useTLS {
+			kind += " (HTTPS)"
+		}
+		fmt.Println("Web " + kind + "：" + l.String())
+		log.Printf("Web服务启动，%s监听：%s", kind, l)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer listener.Close()
+			var err error
+			if useTLS {
+				err = newTLSServer("", h).ServeTLS(listener, p.TLSCert, p.TLSKey)
+			} else {
+				err = (&http.Server{Handler: h}).Serve(listener)
+			}
+			log.Printf("Web服务 %s 停止: %v", l.address, err)
+		}()
+	}
+	wg.Wait()
+}
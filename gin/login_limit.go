@@ -0,0 +1,169 @@
+package serve
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"xuanwu/lib"
+
+	"github.com/tidwall/gjson"
+)
+
+// 登录限制默认值,可在配置文件 login_limit 中修改
+const (
+	defaultMaxFailures     = 5  // 同一账号连续失败次数
+	defaultIPMaxFailures   = 20 // 同一IP连续失败次数
+	defaultLockMinutes     = 15 // 锁定时间
+	defaultMaxDelaySeconds = 30 // 两次尝试之间的最大等待时间
+)
+
+// 登录限制配置
+type loginLimitConfig struct {
+	MaxFailures     int
+	IPMaxFailures   int
+	LockMinutes     int
+	MaxDelaySeconds int
+}
+
+// 失败计数
+type loginCounter struct {
+	failures    int
+	last        time.Time // 最近一次失败时间
+	lockedUntil time.Time
+}
+
+// 登录失败限制,按账号和IP分别计数,只保存在内存中
+type loginLimiter struct {
+	mu       sync.Mutex
+	cfg      loginLimitConfig
+	counters map[string]*loginCounter
+}
+
+var loginLimit = &loginLimiter{
+	cfg: loginLimitConfig{
+		MaxFailures:     defaultMaxFailures,
+		IPMaxFailures:   defaultIPMaxFailures,
+		LockMinutes:     defaultLockMinutes,
+		MaxDelaySeconds: defaultMaxDelaySeconds,
+	},
+	counters: map[string]*loginCounter{},
+}
+
+// 读取配置,未设置或小于等于0时使用默认值
+func (l *loginLimiter) SetConfig(cfg gjson.Result) {
+	value := func(key string, def int) int {
+		if v := cfg.Get(key).Int(); v > 0 {
+			return int(v)
+		}
+		return def
+	}
+	l.mu.Lock()
+	l.cfg = loginLimitConfig{
+		MaxFailures:     value("max_failures", defaultMaxFailures),
+		IPMaxFailures:   value("ip_max_failures", defaultIPMaxFailures),
+		LockMinutes:     value("lock_minutes", defaultLockMinutes),
+		MaxDelaySeconds: value("max_delay_seconds", defaultMaxDelaySeconds),
+	}
+	l.mu.Unlock()
+}
+
+// 计数的名称,用于日志
+func counterName(key string) string {
+	if len(key) > 5 && key[:5] == "user:" {
+		return "账号 " + key[5:]
+	}
+	return "IP " + key[3:]
+}
+
+// 清理已解锁和长时间没有失败的计数,调用方需持有锁
+func (l *loginLimiter) clean(now time.Time) {
+	window := time.Duration(l.cfg.LockMinutes) * time.Minute
+	for key, counter := range l.counters {
+		if !counter.lockedUntil.IsZero() {
+			if now.Before(counter.lockedUntil) {
+				continue
+			}
+			log.Printf("登录解除锁定: %s", counterName(key))
+			delete(l.counters, key)
+		} else if now.Sub(counter.last) > window {
+			delete(l.counters, key)
+		}
+	}
+}
+
+// Check 检查是否允许尝试登录,不允许时返回提示信息
+func (l *loginLimiter) Check(ip, user string) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.clean(now)
+
+	keys := []string{"ip:" + ip, "user:" + user}
+	for _, key := range keys {
+		if counter, ok := l.counters[key]; ok && now.Before(counter.lockedUntil) {
+			minutes := int(counter.lockedUntil.Sub(now).Minutes()) + 1
+			return fmt.Sprintf("登录失败次数过多,请%d分钟后再试", minutes), false
+		}
+	}
+	for _, key := range keys {
+		counter, ok := l.counters[key]
+		if !ok {
+			continue
+		}
+		// 每次失败后等待时间翻倍
+		delay := time.Second << (counter.failures - 1)
+		if maxDelay := time.Duration(l.cfg.MaxDelaySeconds) * time.Second; counter.failures > 16 || delay > maxDelay {
+			delay = maxDelay
+		}
+		if wait := counter.last.Add(delay).Sub(now); wait > 0 {
+			return fmt.Sprintf("尝试过于频繁,请%d秒后再试", int(wait.Seconds())+1), false
+		}
+	}
+	return "", true
+}
+
+// Fail 记录一次失败,超过次数后锁定
+func (l *loginLimiter) Fail(ip, user string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+
+	limits := map[string]int{
+		"ip:" + ip:     l.cfg.IPMaxFailures,
+		"user:" + user: l.cfg.MaxFailures,
+	}
+	for key, limit := range limits {
+		counter, ok := l.counters[key]
+		if !ok {
+			counter = &loginCounter{}
+			l.counters[key] = counter
+		}
+		counter.failures++
+		counter.last = now
+		if counter.failures >= limit && counter.lockedUntil.IsZero() {
+			counter.lockedUntil = now.Add(time.Duration(l.cfg.LockMinutes) * time.Minute)
+			log.Printf("登录锁定: %s 连续失败%d次,锁定%d分钟,来源IP %s", counterName(key), counter.failures, l.cfg.LockMinutes, ip)
+		}
+	}
+}
+
+// Success 登录成功后清除账号的失败计数,IP计数到期后自动清除
+func (l *loginLimiter) Success(user string) {
+	l.mu.Lock()
+	delete(l.counters, "user:"+user)
+	l.mu.Unlock()
+}
+
+var (
+	dummyHash     string
+	dummyHashOnce sync.Once
+)
+
+// 用户不存在时同样计算一次哈希,避免通过响应时间判断用户名是否存在
+func verifyDummyPassword(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = lib.HashPassword(lib.RandomString(16))
+	})
+	lib.VerifyPassword(dummyHash, password)
+}
@@ -0,0 +1,187 @@
+package serve
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"xuanwu/config"
+	"xuanwu/lib/metrics"
+	"xuanwu/lib/pathutil"
+	"xuanwu/xuanwu"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// 接口请求用时
+var httpDuration = metrics.NewHistogramVec("xuanwu_http_request_duration_seconds",
+	"HTTP请求用时",
+	[]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}, "method", "route", "code")
+
+// 指标接口的访问限制,设置了 token 和 allow 时需要同时满足
+type metricsConfig struct {
+	enable bool
+	token  string
+	allow  []*net.IPNet
+}
+
+var metricsCfg = struct {
+	sync.RWMutex
+	metricsConfig
+}{}
+
+// 读取指标配置,启用时必须设置 token 或 allow
+func loadMetrics(cfg gjson.Result) error {
+	m := cfg.Get("metrics")
+	next := metricsConfig{}
+	if m.Get("enable").Bool() {
+		allow, err := parseIPNets(m.Get("allow").Array())
+		if err != nil {
+			return fmt.Errorf("metrics.allow: %v", err)
+		}
+		next.token = m.Get("token").String()
+		next.allow = allow
+		if next.token == "" && len(next.allow) == 0 {
+			return fmt.Errorf("启用 metrics 时需要设置 token 或 allow")
+		}
+		next.enable = true
+	}
+
+	metricsCfg.Lock()
+	metricsCfg.metricsConfig = next
+	metricsCfg.Unlock()
+	return nil
+}
+
+func getMetricsConfig() metricsConfig {
+	metricsCfg.RLock()
+	defer metricsCfg.RUnlock()
+	return metricsCfg.metricsConfig
+}
+
+// MetricsHandler 启用指标时按路由记录请求用时
+func MetricsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !getMetricsConfig().enable {
+			c.Next()
+			return
+		}
+		start := time.Now()
+		c.Next()
+
+		// 未匹配的路由统一记录,避免任意路径产生大量标签
+		route := c.FullPath()
+		if route == "" {
+			route = "static"
+			if strings.HasPrefix(c.Request.URL.Path, "/api") {
+				route = "unmatched"
+			}
+		}
+		httpDuration.Observe(time.Since(start).Seconds(),
+			c.Request.Method, route, strconv.Itoa(c.Writer.Status()))
+	}
+}
+
+// 检查指标接口的访问权限
+func metricsAllowed(c *gin.Context, cfg metricsConfig) bool {
+	if cfg.token != "" {
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.token)) != 1 {
+			return false
+		}
+	}
+	if len(cfg.allow) > 0 {
+		ip := net.ParseIP(c.ClientIP())
+		if ip == nil || !(ipRule{allow: cfg.allow}).allowed(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// 日志目录大小
+func logDirSize() int64 {
+	var size int64
+	filepath.WalkDir(pathutil.GetLogPath(""), func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// HandlerMetrics 以 Prometheus 文本格式输出调度和接口指标
+func HandlerMetrics(c *gin.Context) {
+	cfg := getMetricsConfig()
+	if !cfg.enable {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if !metricsAllowed(c, cfg) {
+		c.Header("WWW-Authenticate", "Bearer")
+		c.String(http.StatusUnauthorized, "unauthorized\n")
+		return
+	}
+
+	var buf bytes.Buffer
+	build := config.BuildInfo()
+	metrics.WriteSamples(&buf, "xuanwu_build_info", "gauge", "版本信息", []metrics.Sample{
+		{Labels: map[string]string{"version": build.Version, "commit": build.Commit, "go_version": build.GoVersion}, Value: 1},
+	})
+	metrics.WriteSamples(&buf, "xuanwu_start_time_seconds", "gauge", "启动时间", []metrics.Sample{
+		{Value: float64(startTime.Unix())},
+	})
+
+	// 按状态统计任务数量
+	enabled, disabled := 0, 0
+	if data, err := config.ReadConfigFileToJson(); err == nil {
+		for _, task := range data.Get("task").Array() {
+			if task.Get("enable").Bool() {
+				enabled++
+			} else {
+				disabled++
+			}
+		}
+	}
+	metrics.WriteSamples(&buf, "xuanwu_tasks", "gauge", "按状态统计的任务数量", []metrics.Sample{
+		{Labels: map[string]string{"state": "enabled"}, Value: float64(enabled)},
+		{Labels: map[string]string{"state": "disabled"}, Value: float64(disabled)},
+	})
+
+	xuanwu.TaskRuns.Write(&buf)
+	xuanwu.TaskDuration.Write(&buf)
+	xuanwu.TaskRunning.Write(&buf)
+
+	next := xuanwu.TaskNextRuns()
+	names := make([]string, 0, len(next))
+	for name := range next {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	samples := make([]metrics.Sample, 0, len(next))
+	for _, name := range names {
+		samples = append(samples, metrics.Sample{Labels: map[string]string{"task": name}, Value: float64(next[name].Unix())})
+	}
+	metrics.WriteSamples(&buf, "xuanwu_task_next_run_timestamp_seconds", "gauge", "任务下次运行时间", samples)
+
+	metrics.WriteSamples(&buf, "xuanwu_log_dir_bytes", "gauge", "日志目录大小", []metrics.Sample{
+		{Value: float64(logDirSize())},
+	})
+	httpDuration.Write(&buf)
+
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", buf.Bytes())
+}
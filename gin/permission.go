@@ -0,0 +1,128 @@
+package serve
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"xuanwu/config"
+	r "xuanwu/gin/response"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// 权限
+const (
+	PERM_CRON_READ  = "cron:read"  // 查看任务和运行记录
+	PERM_CRON_RUN   = "cron:run"   // 执行、启用、禁用任务
+	PERM_CRON_WRITE = "cron:write" // 添加、修改、删除、导入任务
+	PERM_LOG_READ   = "log:read"   // 查看日志目录
+	PERM_FILE_READ  = "file:read"  // 查看数据目录文件
+	PERM_FILE_WRITE = "file:write" // 修改数据目录文件
+	PERM_ADMIN      = "admin"      // 系统设置、用户、备份等全部权限
+)
+
+// 各角色拥有的权限
+var rolePermissions = map[string][]string{
+	config.ROLE_VIEWER:   {PERM_CRON_READ, PERM_LOG_READ},
+	config.ROLE_OPERATOR: {PERM_CRON_READ, PERM_LOG_READ, PERM_CRON_RUN},
+	config.ROLE_ADMIN:    {PERM_ADMIN},
+}
+
+// 权限集合
+type permissions map[string]bool
+
+func newPermissions(list []string) permissions {
+	perms := permissions{}
+	for _, p := range list {
+		perms[p] = true
+	}
+	return perms
+}
+
+// Has 是否拥有指定权限,admin拥有全部权限
+func (p permissions) Has(perm string) bool {
+	return p[PERM_ADMIN] || p[perm]
+}
+
+// 获取当前请求的权限
+func getPermissions(c *gin.Context) permissions {
+	if v, ok := c.Get(r.PermKey); ok {
+		if perms, ok := v.(permissions); ok {
+			return perms
+		}
+	}
+	return permissions{}
+}
+
+// HasPermission 当前请求是否拥有指定权限
+func HasPermission(c *gin.Context, perm string) bool {
+	return getPermissions(c).Has(perm)
+}
+
+// Require 权限检查中间件,拥有任意一个权限即可访问
+func Require(perms ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		granted := getPermissions(c)
+		for _, perm := range perms {
+			if granted.Has(perm) {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"code":    403,
+			"message": "没有操作权限",
+		})
+	}
+}
+
+// 立即执行接口传入自定义命令时相当于修改任务,需要 cron:write 权限
+func requireWriteForCommand(c *gin.Context) {
+	body, err := c.GetRawData()
+	if err != nil {
+		r.ErrMesage(c, "请求参数错误")
+		c.Abort()
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	if (gjson.GetBytes(body, "exec").String() != "" || gjson.GetBytes(body, "workdir").String() != "") &&
+		!HasPermission(c, PERM_CRON_WRITE) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"code":    403,
+			"message": "没有操作权限",
+		})
+		return
+	}
+	c.Next()
+}
+
+// 导入青龙环境变量会修改数据目录中的env.ini,需要 file:write 权限
+func requireFileWriteForEnvs(c *gin.Context) {
+	hasEnvs := false
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		_, err := c.FormFile("envs")
+		hasEnvs = err == nil
+	} else {
+		body, err := c.GetRawData()
+		if err != nil {
+			r.ErrMesage(c, "请求参数错误")
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		envs := gjson.GetBytes(body, "envs")
+		hasEnvs = envs.Exists() && envs.Type != gjson.Null
+	}
+
+	if hasEnvs && !HasPermission(c, PERM_FILE_WRITE) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"code":    403,
+			"message": "导入环境变量需要文件修改权限",
+		})
+		return
+	}
+	c.Next()
+}
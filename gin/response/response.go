@@ -6,6 +6,14 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// 上下文中保存当前用户名、会话ID、访问令牌ID和权限的键
+const (
+	UserKey     = "username"
+	SessionKey  = "session_id"
+	ApiTokenKey = "api_token_id"
+	PermKey     = "permissions"
+)
+
 // 请求失败  http.StatusForbidden 403
 func ErrMesage(c *gin.Context, errmsg interface{}) {
 	c.JSON(http.StatusOK, gin.H{ //请求失败也返回200状态码,显示msg信息
@@ -0,0 +1,169 @@
+package serve
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"xuanwu/config"
+	r "xuanwu/gin/response"
+	"xuanwu/lib"
+	"xuanwu/xuanwu"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+var errTaskNotFound = errors.New("任务不存在")
+
+// 回滚整个配置时保留当前值的字段,避免回滚后无法登录
+var rollbackKeepKeys = []string{"username", "password", "users", "storage"}
+
+// HandlerRevisionList 获取配置修订列表
+func HandlerRevisionList(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	list, err := config.GetStore().ListRevisions(limit)
+	if err != nil {
+		r.ErrMesage(c, "读取修订记录失败")
+		return
+	}
+	r.OkData(c, list)
+}
+
+// HandlerRevisionDiff 比较两个修订,to为空时与当前配置比较
+// 修订中不保存密码等字段,与当前配置比较时同样去掉
+func HandlerRevisionDiff(c *gin.Context) {
+	from, err := strconv.ParseInt(c.Query("from"), 10, 64)
+	if err != nil {
+		r.ErrMesage(c, "修订ID错误")
+		return
+	}
+	fromRev, err := config.GetStore().GetRevision(from)
+	if err != nil {
+		r.ErrMesage(c, err.Error())
+		return
+	}
+
+	var toRaw string
+	if c.Query("to") == "" {
+		cfg, err := config.ReadConfigFileToJson()
+		if err != nil {
+			r.ErrMesage(c, "读取配置文件失败")
+			return
+		}
+		toRaw = string(config.StripSecrets([]byte(cfg.Raw)))
+	} else {
+		to, err := strconv.ParseInt(c.Query("to"), 10, 64)
+		if err != nil {
+			r.ErrMesage(c, "修订ID错误")
+			return
+		}
+		toRev, err := config.GetStore().GetRevision(to)
+		if err != nil {
+			r.ErrMesage(c, err.Error())
+			return
+		}
+		toRaw = string(toRev.Data)
+	}
+
+	r.OkData(c, config.DiffJSON(string(fromRev.Data), toRaw))
+}
+
+// HandlerRollback 回滚单个任务或整个配置到指定修订
+func HandlerRollback(c *gin.Context) {
+	var req struct {
+		ID   int64  `json:"id"`
+		Task string `json:"task"` // 任务名称,同名任务使用对比中的 name#2 等,为空时回滚整个配置
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.ID <= 0 {
+		r.ErrMesage(c, "请求参数错误")
+		return
+	}
+
+	rev, err := config.GetStore().GetRevision(req.ID)
+	if err != nil {
+		r.ErrMesage(c, err.Error())
+		return
+	}
+	snapshot := gjson.ParseBytes(rev.Data)
+
+	// 在保存锁内基于最新配置生成回滚结果,避免覆盖并发的修改
+	err = config.UpdateConfig(c.GetString(r.UserKey), func(cfg gjson.Result) ([]byte, error) {
+		if req.Task != "" {
+			jsonStr, err := rollbackTask(cfg, snapshot, req.Task)
+			return []byte(jsonStr), err
+		}
+		jsonStr := snapshot.Raw
+		for _, key := range rollbackKeepKeys {
+			if value := cfg.Get(key); value.Exists() {
+				jsonStr, _ = sjson.SetRaw(jsonStr, key, value.Raw)
+			} else {
+				jsonStr, _ = sjson.Delete(jsonStr, key)
+			}
+		}
+		return []byte(jsonStr), nil
+	})
+	if errors.Is(err, errTaskNotFound) {
+		r.ErrMesage(c, err.Error())
+		return
+	}
+	if err != nil {
+		r.ErrMesage(c, "回滚失败,配置文件写入失败")
+		return
+	}
+	ReloadConfig()
+
+	r.OkMesage(c, "回滚成功")
+}
+
+// 将单个任务替换为修订中的版本,修订中不存在时删除该任务
+func rollbackTask(cfg, snapshot gjson.Result, name string) (string, error) {
+	var oldTask gjson.Result
+	if i := taskIndexByKey(snapshot, name); i >= 0 {
+		oldTask = snapshot.Get(fmt.Sprintf("task.%d", i))
+	}
+	index := taskIndexByKey(cfg, name)
+
+	switch {
+	case oldTask.Exists() && index >= 0:
+		return sjson.SetRaw(cfg.Raw, fmt.Sprintf("task.%d", index), oldTask.Raw)
+	case oldTask.Exists():
+		return sjson.SetRaw(cfg.Raw, "task.-1", oldTask.Raw)
+	case index >= 0:
+		return sjson.Delete(cfg.Raw, fmt.Sprintf("task.%d", index))
+	}
+	return "", errTaskNotFound
+}
+
+// 按修订对比中的键查找任务下标,同名任务的键为 name#2 等
+func taskIndexByKey(cfg gjson.Result, key string) int {
+	var names []string
+	for _, task := range cfg.Get("task").Array() {
+		names = append(names, task.Get("name").String())
+	}
+	for i, k := range config.ItemKeys(names) {
+		if k == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// ReloadConfig 配置整体变化后重新加载定时任务和全局配置
+func ReloadConfig() {
+	cfg, err := config.ReadConfigFileToJson()
+	if err != nil {
+		return
+	}
+	xuanwu.ReloadTasks(cfg)
+	InitGlobalConfig()
+	xuanwu.UpdateLogCleanDays(GetLogCleanDays())
+	if err := loadIPAccess(cfg); err != nil {
+		log.Printf("IP访问规则或可信代理配置错误,继续使用原设置: %v", err)
+	}
+	// 恢复备份后签名密钥和会话可能已变化
+	lib.ResetSecretCache()
+	sessions.Reset()
+	apiTokens.Reset()
+}
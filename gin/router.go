@@ -4,12 +4,9 @@ import (
 	"fmt"
 	"io/fs"
 	"log"
-	"net"
-	"net/http"
 	"os"
 	"strconv"
 	"strings"
-	"xuanwu/config"
 	"xuanwu/gin/cron"
 	"xuanwu/public"
 
@@ -18,11 +15,13 @@ import (
 )
 
 type ApiData struct {
-	Cookie    string // 解析出来的username
-	Token     string // 未解析的cookie,也就是token
-	RootRoute *gin.Engine
-	AddApi    map[string]string
-	Port      string
+	RootRoute    *gin.Engine
+	AddApi       map[string]string
+	Listen       []listenAddr // 监听地址,可同时监听多个端口和UDS
+	TLSCert      string // 证书路径,为空时使用HTTP
+	TLSKey       string
+	RedirectPort string // HTTP跳转HTTPS的监听端口
+	cfg          gjson.Result
 }
 
 // 判断字符串是否为UDS路径（包含路径分隔符且不是纯数字）
@@ -36,18 +35,33 @@ func isUDSPath(port string) bool {
 }
 
 func InitApi(cfg gjson.Result, addApi map[string]string) {
-	ApiData := &ApiData{
-		Cookie: "", //刷新token
-		Port:   "4165",
-	}
+	ApiData := &ApiData{}
 	ApiData.AddApi = addApi
 
-	// 端口配置优先级：环境变量 XW_PORT > 配置文件 port > 默认值 4165
-	xwPort := os.Getenv("XW_PORT")
-	if xwPort != "" {
-		ApiData.Port = xwPort
-	} else if cfg.Get("port").String() != "" {
-		ApiData.Port = cfg.Get("port").String()
+	// 监听地址优先级：环境变量 XW_PORT > 配置文件 listen > 配置文件 port > 默认值 4165
+	listen, err := ParseListen(cfg, os.Getenv("XW_PORT"))
+	if err != nil {
+		fmt.Println(err)
+		log.Printf("%v,web服务停止", err)
+		return
+	}
+	ApiData.Listen = listen
+
+	ApiData.cfg = cfg
+	if err := loadBasePath(cfg); err != nil {
+		fmt.Println(err)
+		log.Printf("%v,web服务停止", err)
+		return
+	}
+	if err := loadIPAccess(cfg); err != nil {
+		fmt.Println(err)
+		log.Printf("%v,web服务停止", err)
+		return
+	}
+	if err := ApiData.loadTLS(cfg); err != nil {
+		fmt.Println(err)
+		log.Printf("%v,web服务停止", err)
+		return
 	}
 
 	ApiData.Init()
@@ -58,60 +72,108 @@ func (p *ApiData) Init() {
 	gin.SetMode(gin.ReleaseMode) // 关闭gin启动时路由打印
 	RootRoute := gin.Default()
 	p.RootRoute = RootRoute
+	if err := setTrustedProxies(RootRoute); err != nil {
+		log.Printf("可信代理配置错误: %v", err)
+	}
+	RootRoute.Use(ClientIPHandler())  //按可信代理计算客户端IP
+	RootRoute.Use(MetricsHandler())   //请求用时统计
+	RootRoute.Use(IPAccessHandler())  //来源IP限制
 	RootRoute.Use(p.CookieHandler()) //全局用户认证
 
-	// 添加缓存中间件
-	RootRoute.Use(func(c *gin.Context) {
-		path := c.Request.URL.Path
+	// 健康检查和版本,不需要登录
+	RootRoute.GET("/healthz", HandlerHealthz)
+	RootRoute.HEAD("/healthz", HandlerHealthz)
+	RootRoute.GET("/readyz", HandlerReadyz)
+	RootRoute.HEAD("/readyz", HandlerReadyz)
+	RootRoute.GET("/version", HandlerVersion)
+	RootRoute.GET("/metrics", HandlerMetrics) // Prometheus 指标,需要 token 或来源IP限制
 
-		// 缓存过滤
-		shouldNotCache := strings.HasPrefix(path, "/api") ||
-			path == "/" ||
-			path == "/index.html"
-
-		// 静态资源长期缓存（1年）
-		if !shouldNotCache {
-			c.Header("Cache-Control", "public, max-age=31536000, immutable")
-		}
-	})
-
-	routeApi := RootRoute.Group("/api") // api接口总路由
+	routeApi := RootRoute.Group("/api", GzipHandler(), AuditHandler()) // api接口总路由,压缩json并记录修改数据的操作
 
 	// 管理接口
 	routeAdmin := routeApi.Group("/user")
 	routeAdmin.GET("/profile", p.HandlerGetUserProfile)    // 获取用户配置
-	routeAdmin.POST("/profile", p.HandlerUpdateUserProfile) // 更新用户配置
+	routeAdmin.POST("/profile", p.HandlerUpdateUserProfile) // 更新用户配置,系统设置需要管理员权限
+
+	// 用户管理接口
+	routeUsers := routeApi.Group("/users", Require(PERM_ADMIN))
+	routeUsers.GET("/list", HandlerUserList)      // 用户列表
+	routeUsers.POST("/add", HandlerUserAdd)       // 添加用户
+	routeUsers.POST("/update", HandlerUserUpdate) // 修改角色、密码或禁用
+	routeUsers.POST("/delete", HandlerUserDelete) // 删除用户
 
 	// 登录接口
 	routeAuth := routeApi.Group("/auth")
 	routeAuth.POST("/login", p.LoginHandle)
-	routeAuth.GET("/logout", p.LogoutHandler)
-	routeAuth.GET("/check-default", p.CheckDefaultCredentials) // 检查是否为默认用户名密码
+	routeAuth.POST("/login/totp", p.LoginTOTPHandle)     // 登录第二步,输入两步验证码
+	routeAuth.GET("/oidc", HandlerOIDCInfo)              // 是否启用单点登录
+	routeAuth.GET("/oidc/login", HandlerOIDCLogin)       // 跳转到OIDC提供方
+	routeAuth.GET("/oidc/callback", HandlerOIDCCallback) // OIDC登录回调
+	routeAuth.POST("/logout", p.LogoutHandler)
+	routeAuth.GET("/check-default", p.CheckDefaultCredentials)                   // 检查是否为默认用户名密码
+	routeAuth.POST("/rotate-secret", Require(PERM_ADMIN), p.HandlerRotateSecret) // 更换token签名密钥
+	routeAuth.GET("/sessions", HandlerSessionList)                               // 登录会话列表
+	routeAuth.POST("/sessions/revoke", p.HandlerSessionRevoke)                   // 注销指定会话或全部会话
+
+	// 两步验证接口
+	routeTOTP := routeAuth.Group("/totp", requireSession)
+	routeTOTP.GET("/status", HandlerTOTPStatus)                 // 两步验证状态
+	routeTOTP.POST("/setup", HandlerTOTPSetup)                  // 生成密钥和二维码
+	routeTOTP.POST("/enable", HandlerTOTPEnable)                // 输入验证码确认开启
+	routeTOTP.POST("/disable", HandlerTOTPDisable)              // 输入密码关闭
+	routeTOTP.POST("/recovery-codes", HandlerTOTPRecoveryCodes) // 输入密码重新生成恢复码
+
+	// 个人访问令牌接口
+	routeTokens := routeApi.Group("/tokens", requireSession)
+	routeTokens.GET("/list", HandlerApiTokenList)      // 令牌列表
+	routeTokens.POST("/create", HandlerApiTokenCreate) // 创建令牌
+	routeTokens.POST("/revoke", HandlerApiTokenRevoke) // 删除令牌
 
 	// 定时任务接口
 	routeCron := routeApi.Group("/cron")
+	cronRead := Require(PERM_CRON_READ)
+	cronRun := Require(PERM_CRON_RUN)
+	cronWrite := Require(PERM_CRON_WRITE)
 	/* 任务源 */
-	routeCron.GET("/list", cron.HandlerTaskList)    //获取任务列表（包含运行状态）
-	routeCron.GET("/delete", cron.HandlerDeleteTask)   //删除源任务
-	routeCron.POST("/add", cron.HandlerAddTask)        //添加任务源
-	routeCron.POST("/batch-add", cron.HandlerBatchAddTask) //批量添加任务源
-	routeCron.POST("/update", cron.HandlerAddTask)     //更新任务（复用添加接口）
+	routeCron.GET("/list", cronRead, cron.HandlerTaskList)          //获取任务列表（包含运行状态）
+	routeCron.POST("/delete", cronWrite, cron.HandlerDeleteTask)     //删除源任务
+	routeCron.POST("/add", cronWrite, cron.HandlerAddTask)          //添加任务源
+	routeCron.POST("/batch-add", cronWrite, cron.HandlerBatchAddTask) //批量添加任务源
+	routeCron.POST("/update", cronWrite, cron.HandlerAddTask)       //更新任务（复用添加接口）
+	routeCron.GET("/export", cronRead, cron.HandlerExportTask)      //导出任务 json/yaml/crontab
+	routeCron.POST("/import", cronWrite, cron.HandlerImportTask)    //导入任务,支持预览
+	routeCron.POST("/import/qinglong", cronWrite, requireFileWriteForEnvs, cron.HandlerImportQinglong) //导入青龙任务和环境变量,环境变量需要 file:write
 	/* 任务控制 */
-	routeCron.GET("/enable", cron.HandlerEnableTask)   //启用任务
-	routeCron.GET("/disable", cron.HandlerDisableTask) //禁用任务
-	routeCron.POST("/execute", cron.HandlerExecuteTask) //立即执行任务
-
-	// 文件管理接口
+	routeCron.POST("/enable", cronRun, cron.HandlerEnableTask)    //启用任务
+	routeCron.POST("/disable", cronRun, cron.HandlerDisableTask)  //禁用任务
+	routeCron.POST("/execute", cronRun, requireWriteForCommand, cron.HandlerExecuteTask) //立即执行任务
+	routeCron.GET("/runs", cronRead, cron.HandlerRunList)        //任务运行记录
+
+	// 配置修订接口
+	routeConfig := routeApi.Group("/config", Require(PERM_ADMIN))
+	routeConfig.GET("/revisions", HandlerRevisionList)      // 修订列表
+	routeConfig.GET("/revisions/diff", HandlerRevisionDiff) // 比较修订
+	routeConfig.POST("/rollback", HandlerRollback)          // 回滚任务或整个配置
+
+	// 备份恢复接口
+	routeSystem := routeApi.Group("/system", Require(PERM_ADMIN))
+	routeSystem.GET("/backup", HandlerBackup)   // 下载数据目录备份
+	routeSystem.POST("/restore", HandlerRestore) // 上传备份并恢复
+	routeSystem.GET("/audit", HandlerAuditList)  // 查询审计记录
+
+	// 文件管理接口,只有日志权限时只能查看日志目录
 	routeFile := routeApi.Group("/file")
-	routeFile.GET("/list", HandlerFileList)       // 获取文件列表
-	routeFile.POST("/upload", HandlerFileUpload)  // 上传文件
-	routeFile.POST("/batch-upload", HandlerBatchUpload) // 批量上传文件
-	routeFile.POST("/mkdir", HandlerMkdir)       // 创建文件夹
-	routeFile.GET("/download", HandlerFileDownload) // 下载文件
-	routeFile.GET("/content", HandlerFileContent) // 获取文件内容
-	routeFile.POST("/edit", HandlerFileEdit)     // 编辑文件
-	routeFile.GET("/delete", HandlerFileDelete)  // 删除文件
-	routeFile.POST("/rename", HandlerFileRename) // 重命名文件
+	fileRead := Require(PERM_FILE_READ, PERM_LOG_READ)
+	fileWrite := Require(PERM_FILE_WRITE)
+	routeFile.GET("/list", fileRead, HandlerFileList)       // 获取文件列表
+	routeFile.POST("/upload", fileWrite, HandlerFileUpload)  // 上传文件
+	routeFile.POST("/batch-upload", fileWrite, HandlerBatchUpload) // 批量上传文件
+	routeFile.POST("/mkdir", fileWrite, HandlerMkdir)       // 创建文件夹
+	routeFile.GET("/download", fileRead, HandlerFileDownload) // 下载文件
+	routeFile.GET("/content", fileRead, HandlerFileContent) // 获取文件内容
+	routeFile.POST("/edit", fileWrite, HandlerFileEdit)     // 编辑文件
+	routeFile.POST("/delete", fileWrite, HandlerFileDelete)  // 删除文件
+	routeFile.POST("/rename", fileWrite, HandlerFileRename) // 重命名文件
 
 	// 静态文件处理
 	distFS, err := fs.Sub(public.Public, "dist")
@@ -119,74 +181,23 @@ func (p *ApiData) Init() {
 		log.Println("加载后台文件失败,web服务停止")
 		return
 	}
+	static, err := loadUI(p.cfg, distFS)
+	if err != nil {
+		log.Printf("加载后台文件失败,web服务停止: %v", err)
+		return
+	}
 
 	// 使用 NoRoute 处理所有非 API 请求
-	RootRoute.NoRoute(func(c *gin.Context) {
-		// 如果是 API 请求，返回 404
-		if strings.HasPrefix(c.Request.URL.Path, "/api") {
-			c.Status(http.StatusNotFound)
-			return
-		}
-
-		// 非 API 请求，尝试提供静态文件
-		path := c.Request.URL.Path
-		if path == "/" {
-			path = "index.html"
-		}
-
-		content, err := fs.ReadFile(distFS, strings.TrimPrefix(path, "/"))
-		if err != nil {
-			// 如果文件不存在，返回 index.html
-			content, err = fs.ReadFile(distFS, "index.html")
-			if err != nil {
-				c.Status(http.StatusNotFound)
-				return
-			}
-		}
-
-		// 设置适当的 Content-Type
-		if strings.HasSuffix(path, ".html") {
-			c.Header("Content-Type", "text/html")
-		} else if strings.HasSuffix(path, ".css") {
-			c.Header("Content-Type", "text/css")
-		} else if strings.HasSuffix(path, ".js") {
-			c.Header("Content-Type", "application/javascript")
-		}
-
-		c.Data(http.StatusOK, c.ContentType(), content)
-	})
-
-	// 判断是否使用 UDS (Unix Domain Socket)
-	if isUDSPath(p.Port) && !config.IsWindows {
-		// 使用 UDS 监听
-		socketPath := p.Port
-		// 删除可能存在的旧socket文件
-		os.Remove(socketPath)
-
-		listener, err := net.Listen("unix", socketPath)
-		if err != nil {
-			log.Printf("UDS 监听失败: %v", err)
-			return
-		}
-		defer listener.Close()
-
-		// 设置socket文件权限为0666，确保nginx/caddy等反向代理可以访问
-		if err := os.Chmod(socketPath, 0666); err != nil {
-			log.Printf("设置UDS权限失败: %v", err)
-			return
-		}
-
-		fmt.Println("Web UDS：" + socketPath + " (权限: 0666)")
-		log.Printf("Web服务启动，UDS监听：%s (权限: 0666)", socketPath)
-
-		server := &http.Server{Handler: RootRoute}
-		if err := server.Serve(listener); err != nil {
-			log.Printf("UDS 服务启动失败: %v", err)
-		}
-	} else {
-		// 使用端口监听
-		fmt.Println("Web 端口：" + p.Port)
-		log.Printf("Web服务启动，端口监听：%s", p.Port)
-		RootRoute.Run(":" + p.Port)
+	RootRoute.NoRoute(static.Handler)
+
+	// 有访问路径前缀时去掉前缀再交给路由
+	handler := basePathHandler(RootRoute)
+	if basePath != "" {
+		fmt.Println("访问路径：" + basePath + "/")
+	}
+
+	if tlsEnabled {
+		go p.serveRedirect()
 	}
+	p.serveAll(handler)
 }
@@ -0,0 +1,256 @@
+package serve
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+	"xuanwu/config"
+	r "xuanwu/gin/response"
+	"xuanwu/lib"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	sessionTouchInterval = time.Minute // 最近使用时间的写入间隔,避免每个请求都写存储
+	sessionCleanInterval = time.Hour   // 清理过期会话的间隔
+)
+
+// 会话缓存,启动后首次使用时从存储加载
+type sessionCache struct {
+	mu        sync.Mutex
+	items     map[string]*config.Session
+	saved     map[string]time.Time // 每个会话最后一次写入存储的时间
+	lastClean time.Time
+}
+
+var sessions = &sessionCache{}
+
+// 加载会话,调用方需持有锁
+func (m *sessionCache) load() {
+	if m.items != nil {
+		return
+	}
+	m.items = map[string]*config.Session{}
+	m.saved = map[string]time.Time{}
+	list, err := config.GetStore().ListSessions()
+	if err != nil {
+		log.Printf("读取会话失败: %v", err)
+		return
+	}
+	now := time.Now()
+	for i := range list {
+		if list[i].Expires.After(now) {
+			m.items[list[i].ID] = &list[i]
+			m.saved[list[i].ID] = now
+		}
+	}
+}
+
+// 定期删除过期会话,调用方需持有锁
+func (m *sessionCache) clean(now time.Time) {
+	if now.Sub(m.lastClean) < sessionCleanInterval {
+		return
+	}
+	m.lastClean = now
+	for id, sess := range m.items {
+		if !sess.Expires.After(now) {
+			delete(m.items, id)
+			delete(m.saved, id)
+		}
+	}
+	if err := config.GetStore().DeleteExpiredSessions(now); err != nil {
+		log.Printf("清理过期会话失败: %v", err)
+	}
+}
+
+// Create 登录成功后记录会话
+func (m *sessionCache) Create(claims *lib.TokenClaims, c *gin.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+
+	now := time.Now()
+	sess := &config.Session{
+		ID:        claims.ID,
+		User:      claims.User,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		Created:   now,
+		LastSeen:  now,
+		Expires:   time.Unix(claims.Exp, 0),
+	}
+	if err := config.GetStore().SaveSession(*sess); err != nil {
+		return err
+	}
+	m.items[sess.ID] = sess
+	m.saved[sess.ID] = now
+	m.clean(now)
+	return nil
+}
+
+// Touch 检查会话是否有效并更新最近使用时间
+func (m *sessionCache) Touch(id string, c *gin.Context) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+
+	now := time.Now()
+	m.clean(now)
+	sess, ok := m.items[id]
+	if !ok || !sess.Expires.After(now) {
+		return false
+	}
+	sess.LastSeen = now
+	sess.IP = c.ClientIP()
+	if now.Sub(m.saved[id]) >= sessionTouchInterval {
+		if err := config.GetStore().SaveSession(*sess); err == nil {
+			m.saved[id] = now
+		}
+	}
+	return true
+}
+
+// List 获取有效会话,按最近使用时间倒序
+func (m *sessionCache) List() []config.Session {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+
+	now := time.Now()
+	list := []config.Session{}
+	for _, sess := range m.items {
+		if sess.Expires.After(now) {
+			list = append(list, *sess)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastSeen.After(list[j].LastSeen)
+	})
+	return list
+}
+
+// Revoke 注销指定会话,返回实际注销的数量
+func (m *sessionCache) Revoke(ids ...string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+
+	var found []string
+	for _, id := range ids {
+		if _, ok := m.items[id]; ok {
+			found = append(found, id)
+			delete(m.items, id)
+			delete(m.saved, id)
+		}
+	}
+	if len(found) > 0 {
+		if err := config.GetStore().DeleteSessions(found...); err != nil {
+			log.Printf("删除会话失败: %v", err)
+		}
+	}
+	return len(found)
+}
+
+// RevokeAll 注销全部会话
+func (m *sessionCache) RevokeAll() int {
+	m.mu.Lock()
+	var ids []string
+	m.load()
+	for id := range m.items {
+		ids = append(ids, id)
+	}
+	m.mu.Unlock()
+	return m.Revoke(ids...)
+}
+
+// Owns 会话是否属于指定用户
+func (m *sessionCache) Owns(id, user string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+	sess, ok := m.items[id]
+	return ok && sess.User == user
+}
+
+// RevokeUser 注销指定用户的全部会话
+func (m *sessionCache) RevokeUser(user string) int {
+	m.mu.Lock()
+	var ids []string
+	m.load()
+	for id, sess := range m.items {
+		if sess.User == user {
+			ids = append(ids, id)
+		}
+	}
+	m.mu.Unlock()
+	return m.Revoke(ids...)
+}
+
+// Reset 清除缓存,下次使用时从存储重新加载
+func (m *sessionCache) Reset() {
+	m.mu.Lock()
+	m.items = nil
+	m.saved = nil
+	m.mu.Unlock()
+}
+
+// 会话信息,标记是否为当前请求的会话
+type sessionInfo struct {
+	config.Session
+	Current bool `json:"current"`
+}
+
+// HandlerSessionList 获取登录会话列表,管理员可查看所有用户的会话
+func HandlerSessionList(c *gin.Context) {
+	current := c.GetString(r.SessionKey)
+	user := c.GetString(r.UserKey)
+	isAdmin := HasPermission(c, PERM_ADMIN)
+	list := []sessionInfo{}
+	for _, sess := range sessions.List() {
+		if isAdmin || sess.User == user {
+			list = append(list, sessionInfo{Session: sess, Current: sess.ID == current})
+		}
+	}
+	r.OkData(c, list)
+}
+
+// HandlerSessionRevoke 注销指定会话或全部会话,非管理员只能注销自己的会话
+func (p *ApiData) HandlerSessionRevoke(c *gin.Context) {
+	var req struct {
+		ID  string `json:"id"`
+		All bool   `json:"all"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || (req.ID == "" && !req.All) {
+		r.ErrMesage(c, "请求参数错误")
+		return
+	}
+
+	user := c.GetString(r.UserKey)
+	isAdmin := HasPermission(c, PERM_ADMIN)
+	var count int
+	if req.All {
+		if isAdmin {
+			count = sessions.RevokeAll()
+		} else {
+			count = sessions.RevokeUser(user)
+		}
+	} else {
+		if !isAdmin && !sessions.Owns(req.ID, user) {
+			r.ErrMesage(c, "会话不存在")
+			return
+		}
+		count = sessions.Revoke(req.ID)
+		if count == 0 {
+			r.ErrMesage(c, "会话不存在")
+			return
+		}
+	}
+
+	// 注销了当前会话时同时清除cookie
+	if req.All || req.ID == c.GetString(r.SessionKey) {
+		p.ClearUserToken(c)
+	}
+	r.OkMesageData(c, "注销成功", gin.H{"count": count})
+}
@@ -0,0 +1,305 @@
+package serve
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"xuanwu/config"
+	r "xuanwu/gin/response"
+	"xuanwu/lib"
+	"xuanwu/lib/oidc"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	defaultAuthHeader    = "Remote-User"
+	defaultUsernameClaim = "preferred_username"
+	oidcStateCookie      = "oidc_state"
+	oidcStateTTL         = 10 * time.Minute
+)
+
+// 单点登录配置
+type ssoConfig struct {
+	headerEnable  bool
+	header        string
+	headerProxies []*net.IPNet // 只接受来自这些代理的用户名请求头
+
+	provider      *oidc.Provider // 为空表示未启用OIDC
+	usernameClaim string
+	autoCreate    bool   // 本地不存在时自动创建用户
+	defaultRole   string // 自动创建用户的角色
+	linkExisting  bool   // 允许登录设置了密码的本地用户,默认只对应没有密码的单点登录用户
+}
+
+// 登录跳转时保存的状态
+type oidcState struct {
+	nonce    string
+	verifier string
+	expires  time.Time
+}
+
+var sso = struct {
+	sync.RWMutex
+	cfg    ssoConfig
+	states map[string]oidcState
+}{states: map[string]oidcState{}}
+
+// 读取单点登录配置,发现文档和公钥在首次登录时获取
+func loadSSO(cfg gjson.Result) error {
+	next := ssoConfig{}
+
+	header := cfg.Get("auth_header")
+	if header.Get("enable").Bool() {
+		next.headerEnable = true
+		next.header = header.Get("header").String()
+		if next.header == "" {
+			next.header = defaultAuthHeader
+		}
+		proxies := header.Get("proxies").Array()
+		if len(proxies) == 0 {
+			proxies = cfg.Get("trusted_proxies").Array()
+		}
+		nets, err := parseIPNets(proxies)
+		if err != nil {
+			return fmt.Errorf("auth_header.proxies: %v", err)
+		}
+		if len(nets) == 0 {
+			return fmt.Errorf("启用 auth_header 时需要设置 proxies 或 trusted_proxies")
+		}
+		next.headerProxies = nets
+	}
+
+	o := cfg.Get("oidc")
+	if o.Get("enable").Bool() {
+		if o.Get("issuer").String() == "" || o.Get("client_id").String() == "" || o.Get("redirect_url").String() == "" {
+			return fmt.Errorf("oidc 需要设置 issuer、client_id 和 redirect_url")
+		}
+		next.usernameClaim = o.Get("username_claim").String()
+		if next.usernameClaim == "" {
+			next.usernameClaim = defaultUsernameClaim
+		}
+		next.autoCreate = o.Get("auto_create").Bool()
+		next.linkExisting = o.Get("link_existing").Bool()
+		next.defaultRole = o.Get("default_role").String()
+		if next.defaultRole == "" {
+			next.defaultRole = config.ROLE_VIEWER
+		}
+		if !config.IsValidRole(next.defaultRole) {
+			return fmt.Errorf("oidc.default_role 无效: %s", next.defaultRole)
+		}
+		var scopes []string
+		for _, s := range o.Get("scopes").Array() {
+			scopes = append(scopes, s.String())
+		}
+		next.provider = oidc.NewProvider(oidc.Config{
+			Issuer:       o.Get("issuer").String(),
+			ClientID:     o.Get("client_id").String(),
+			ClientSecret: o.Get("client_secret").String(),
+			RedirectURL:  o.Get("redirect_url").String(),
+			Scopes:       scopes,
+		})
+	}
+
+	sso.Lock()
+	sso.cfg = next
+	sso.Unlock()
+	return nil
+}
+
+// 获取当前配置
+func getSSO() ssoConfig {
+	sso.RLock()
+	defer sso.RUnlock()
+	return sso.cfg
+}
+
+// 是否启用了单点登录
+func ssoEnabled() bool {
+	cfg := getSSO()
+	return cfg.headerEnable || cfg.provider != nil
+}
+
+// 使用反代传来的用户名请求头认证,只信任来自配置代理的直接连接
+func authByHeader(c *gin.Context) bool {
+	cfg := getSSO()
+	if !cfg.headerEnable {
+		return false
+	}
+	username := strings.TrimSpace(c.GetHeader(cfg.header))
+	if username == "" {
+		return false
+	}
+	// UDS连接只来自本机反代,同样视为可信
+	if !isUDSRequest(c.Request) {
+		peer := net.ParseIP(c.RemoteIP())
+		if peer == nil || !containsIP(cfg.headerProxies, peer) {
+			return false
+		}
+	}
+	user, ok := currentUser(username)
+	if !ok || user.Disabled {
+		return false
+	}
+	c.Set(r.UserKey, user.Username)
+	c.Set(r.PermKey, newPermissions(rolePermissions[user.Role]))
+	return true
+}
+
+// 保存登录跳转状态,同时清理过期状态
+func saveOIDCState(state string, s oidcState) {
+	sso.Lock()
+	defer sso.Unlock()
+	now := time.Now()
+	for k, v := range sso.states {
+		if now.After(v.expires) {
+			delete(sso.states, k)
+		}
+	}
+	sso.states[state] = s
+}
+
+// 取出登录跳转状态,只能使用一次
+func takeOIDCState(state string) (oidcState, bool) {
+	sso.Lock()
+	defer sso.Unlock()
+	s, ok := sso.states[state]
+	delete(sso.states, state)
+	if !ok || time.Now().After(s.expires) {
+		return oidcState{}, false
+	}
+	return s, true
+}
+
+// 按claim查找本地用户,开启 auto_create 时自动创建没有密码的用户
+// 默认只对应没有密码的用户,避免提供方的用户名与本地账号(如admin)相同时直接登录
+func oidcUser(cfg ssoConfig, claims map[string]interface{}) (config.User, error) {
+	value, ok := claims[cfg.usernameClaim]
+	if !ok || value == nil {
+		return config.User{}, fmt.Errorf("id_token中没有 %s", cfg.usernameClaim)
+	}
+	username := strings.TrimSpace(fmt.Sprint(value))
+	if !validUsername(username) {
+		return config.User{}, fmt.Errorf("用户名无效: %s", username)
+	}
+	if user, ok := currentUser(username); ok {
+		// 有密码的本地用户(包括默认管理员)需要管理员明确允许后才能通过单点登录
+		if user.Password != "" && !cfg.linkExisting {
+			return config.User{}, fmt.Errorf("用户 %s 是本地用户,未开启 link_existing", username)
+		}
+		return user, nil
+	}
+	if !cfg.autoCreate {
+		return config.User{}, fmt.Errorf("用户 %s 不存在", username)
+	}
+
+	user := config.User{Username: username, Role: cfg.defaultRole}
+	err := saveUsers("oidc", func(users []config.User) ([]config.User, error) {
+		for _, u := range users {
+			if u.Username == username {
+				return users, nil
+			}
+		}
+		return append(users, user), nil
+	})
+	if err != nil {
+		return config.User{}, err
+	}
+	log.Printf("单点登录自动创建用户: %s (%s)", username, user.Role)
+	return user, nil
+}
+
+// HandlerOIDCInfo 单点登录是否可用,供登录页显示按钮
+func HandlerOIDCInfo(c *gin.Context) {
+	r.OkData(c, gin.H{
+		"enabled": getSSO().provider != nil,
+	})
+}
+
+// HandlerOIDCLogin 跳转到OIDC提供方登录
+func HandlerOIDCLogin(c *gin.Context) {
+	provider := getSSO().provider
+	if provider == nil {
+		c.String(http.StatusNotFound, "未启用单点登录")
+		return
+	}
+
+	state := lib.RandomString(16)
+	s := oidcState{
+		nonce:    lib.RandomString(16),
+		verifier: lib.RandomString(32),
+		expires:  time.Now().Add(oidcStateTTL),
+	}
+	u, err := provider.AuthCodeURL(state, s.nonce, s.verifier)
+	if err != nil {
+		log.Printf("获取OIDC配置失败: %v", err)
+		c.String(http.StatusBadGateway, "获取单点登录配置失败")
+		return
+	}
+	saveOIDCState(state, s)
+
+	// 提供方跳转回来属于跨站请求,需要使用 Lax
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, int(oidcStateTTL.Seconds()), cookiePath(), "", tlsEnabled, true)
+	c.Redirect(http.StatusFound, u)
+}
+
+// HandlerOIDCCallback 提供方登录后的回调,校验后创建会话并跳转到首页
+func HandlerOIDCCallback(c *gin.Context) {
+	cfg := getSSO()
+	if cfg.provider == nil {
+		c.String(http.StatusNotFound, "未启用单点登录")
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, "", -1, cookiePath(), "", tlsEnabled, true)
+
+	if e := c.Query("error"); e != "" {
+		c.String(http.StatusUnauthorized, "单点登录失败: "+e+" "+c.Query("error_description"))
+		return
+	}
+	state := c.Query("state")
+	cookie, _ := c.Cookie(oidcStateCookie)
+	s, ok := takeOIDCState(state)
+	if !ok || state == "" || cookie != state {
+		c.String(http.StatusBadRequest, "登录已过期,请重新登录")
+		return
+	}
+
+	fail := func(err error) {
+		log.Printf("单点登录失败: %v", err)
+		c.String(http.StatusUnauthorized, "单点登录失败: "+err.Error())
+	}
+	idToken, err := cfg.provider.Exchange(c.Query("code"), s.verifier)
+	if err != nil {
+		fail(err)
+		return
+	}
+	claims, err := cfg.provider.VerifyIDToken(idToken, s.nonce)
+	if err != nil {
+		fail(err)
+		return
+	}
+	user, err := oidcUser(cfg, claims)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if user.Disabled {
+		fail(fmt.Errorf("用户 %s 已被禁用", user.Username))
+		return
+	}
+
+	if _, err := createLoginSession(c, user); err != nil {
+		log.Printf("保存会话失败: %v", err)
+		c.String(http.StatusInternalServerError, "登录失败")
+		return
+	}
+	log.Printf("单点登录成功: %s", user.Username)
+	c.Redirect(http.StatusFound, basePath+"/")
+}
@@ -0,0 +1,223 @@
+package serve
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	staticGzipMinSize = 1 << 10 // 小于该大小的文件不压缩
+
+	cacheImmutable  = "public, max-age=31536000, immutable"
+	cacheRevalidate = "no-cache" // 每次使用前通过 ETag 验证
+)
+
+// 标准库未包含或系统中可能缺少的类型
+var staticMimeTypes = map[string]string{
+	".js":          "application/javascript; charset=utf-8",
+	".mjs":         "application/javascript; charset=utf-8",
+	".css":         "text/css; charset=utf-8",
+	".html":        "text/html; charset=utf-8",
+	".json":        "application/json",
+	".map":         "application/json",
+	".webmanifest": "application/manifest+json",
+	".svg":         "image/svg+xml",
+	".ico":         "image/x-icon",
+	".woff":        "font/woff",
+	".woff2":       "font/woff2",
+	".ttf":         "font/ttf",
+	".otf":         "font/otf",
+	".wasm":        "application/wasm",
+	".txt":         "text/plain; charset=utf-8",
+}
+
+// 启动时读取的静态文件
+type staticFile struct {
+	content     []byte
+	gzip        []byte // 预压缩内容,为空表示不压缩
+	brotli      []byte // 只能使用构建时生成的 .br 文件
+	etag        string
+	contentType string
+}
+
+type staticFiles struct {
+	files   map[string]*staticFile
+	modTime time.Time
+	noCache bool // 开发模式下文件会变化,不使用长期缓存
+}
+
+// 获取文件类型
+func staticContentType(name string, content []byte) string {
+	ext := strings.ToLower(path.Ext(name))
+	if t, ok := staticMimeTypes[ext]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return http.DetectContentType(content)
+}
+
+// 文本类文件才值得压缩
+func compressible(contentType string) bool {
+	t, _, _ := strings.Cut(contentType, ";")
+	return strings.HasPrefix(t, "text/") ||
+		strings.HasSuffix(t, "javascript") ||
+		strings.HasSuffix(t, "json") ||
+		strings.HasSuffix(t, "+xml") ||
+		strings.HasSuffix(t, "/xml") ||
+		t == "application/wasm" ||
+		t == "font/ttf" || t == "font/otf"
+}
+
+func gzipBytes(content []byte) []byte {
+	var buf bytes.Buffer
+	gz, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	gz.Write(content)
+	gz.Close()
+	return buf.Bytes()
+}
+
+// 读取所有静态文件,计算 ETag 并生成压缩版本,index.html 加上访问路径前缀
+// 后面的目录中的同名文件覆盖前面的
+func loadStatic(layers ...fs.FS) (*staticFiles, error) {
+	s := &staticFiles{files: map[string]*staticFile{}}
+	// 嵌入的文件没有修改时间,使用程序文件的修改时间
+	if exe, err := os.Executable(); err == nil {
+		if info, err := os.Stat(exe); err == nil {
+			s.modTime = info.ModTime()
+		}
+	}
+
+	for _, fsys := range layers {
+		err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			ext := path.Ext(name)
+			if ext == ".gz" || ext == ".br" {
+				return nil
+			}
+			f, err := loadStaticFile(fsys, name)
+			if err != nil {
+				return err
+			}
+			if info, err := d.Info(); err == nil && info.ModTime().After(s.modTime) {
+				s.modTime = info.ModTime()
+			}
+			s.files[name] = f
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// 读取单个文件,优先使用构建时生成的压缩文件,index.html 改写过不能使用
+func loadStaticFile(fsys fs.FS, name string) (*staticFile, error) {
+	content, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	if name == "index.html" {
+		content = rewriteIndex(content)
+	}
+
+	sum := sha256.Sum256(content)
+	f := &staticFile{
+		content:     content,
+		etag:        `W/"` + hex.EncodeToString(sum[:8]) + `"`,
+		contentType: staticContentType(name, content),
+	}
+	if compressible(f.contentType) && len(content) >= staticGzipMinSize {
+		if gz, err := fs.ReadFile(fsys, name+".gz"); err == nil && name != "index.html" {
+			f.gzip = gz
+		} else if gz := gzipBytes(content); len(gz) < len(content) {
+			f.gzip = gz
+		}
+		if br, err := fs.ReadFile(fsys, name+".br"); err == nil && name != "index.html" {
+			f.brotli = br
+		}
+	}
+	return f, nil
+}
+
+// 客户端是否接受该编码
+func acceptsEncoding(req *http.Request, encoding string) bool {
+	for _, part := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.EqualFold(strings.TrimSpace(name), encoding) {
+			return strings.ReplaceAll(params, " ", "") != "q=0"
+		}
+	}
+	return false
+}
+
+// 返回静态文件,支持 ETag/Last-Modified 验证和预压缩内容
+func (s *staticFiles) serve(c *gin.Context, name string, f *staticFile, cacheControl string) {
+	h := c.Writer.Header()
+	h.Set("Content-Type", f.contentType)
+	h.Set("Cache-Control", cacheControl)
+	h.Set("ETag", f.etag)
+
+	content := f.content
+	if f.gzip != nil || f.brotli != nil {
+		h.Add("Vary", "Accept-Encoding")
+		switch {
+		case f.brotli != nil && acceptsEncoding(c.Request, "br"):
+			h.Set("Content-Encoding", "br")
+			content = f.brotli
+		case f.gzip != nil && acceptsEncoding(c.Request, "gzip"):
+			h.Set("Content-Encoding", "gzip")
+			content = f.gzip
+		}
+	}
+	http.ServeContent(c.Writer, c.Request, name, s.modTime, bytes.NewReader(content))
+}
+
+// Handler 提供前端文件,不存在的路径返回 index.html 由前端路由处理
+// 只有实际存在的文件才长期缓存,index.html 每次验证
+func (s *staticFiles) Handler(c *gin.Context) {
+	// 如果是 API 请求，返回 404
+	if strings.HasPrefix(c.Request.URL.Path, "/api") {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		c.Status(http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean(c.Request.URL.Path), "/")
+	if name == "" {
+		name = "index.html"
+	}
+	if f, ok := s.files[name]; ok && name != "index.html" {
+		cacheControl := cacheImmutable
+		if s.noCache {
+			cacheControl = cacheRevalidate
+		}
+		s.serve(c, name, f, cacheControl)
+		return
+	}
+
+	index, ok := s.files["index.html"]
+	if !ok {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	s.serve(c, "index.html", index, cacheRevalidate)
+}
@@ -0,0 +1,92 @@
+package serve
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"xuanwu/lib/pathutil"
+	"xuanwu/lib/tlsutil"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// 是否启用了HTTPS,启用后cookie只通过HTTPS发送
+var tlsEnabled bool
+
+// 读取证书配置,相对路径以数据目录为基准
+// 配置 tls_cert 和 tls_key 时使用指定证书,否则 tls_self_signed 为 true 时使用自签名证书
+func (p *ApiData) loadTLS(cfg gjson.Result) error {
+	cert := cfg.Get("tls_cert").String()
+	key := cfg.Get("tls_key").String()
+	switch {
+	case cert != "" && key != "":
+		if !filepath.IsAbs(cert) {
+			cert = pathutil.GetDataPath(cert)
+		}
+		if !filepath.IsAbs(key) {
+			key = pathutil.GetDataPath(key)
+		}
+		if _, err := tls.LoadX509KeyPair(cert, key); err != nil {
+			return fmt.Errorf("加载证书失败: %v", err)
+		}
+	case cert != "" || key != "":
+		return fmt.Errorf("tls_cert 和 tls_key 需要同时设置")
+	case cfg.Get("tls_self_signed").Bool():
+		var created bool
+		var err error
+		if cert, key, created, err = tlsutil.EnsureSelfSigned(); err != nil {
+			return fmt.Errorf("生成自签名证书失败: %v", err)
+		}
+		if created {
+			log.Printf("已生成自签名证书: %s", cert)
+		}
+	default:
+		return nil
+	}
+
+	p.TLSCert, p.TLSKey = cert, key
+	p.RedirectPort = cfg.Get("http_redirect_port").String()
+	tlsEnabled = true
+	return nil
+}
+
+// 新建HTTPS服务
+func newTLSServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:      addr,
+		Handler:   handler,
+		TLSConfig: &tls.Config{MinVersion: tls.VersionTLS12},
+	}
+}
+
+// 监听HTTP端口并跳转到HTTPS
+func (p *ApiData) serveRedirect() {
+	port := p.tlsPort()
+	if p.RedirectPort == "" || port == "" {
+		return
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, req, "https://"+host+req.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+	log.Printf("HTTP跳转HTTPS，端口监听：%s", p.RedirectPort)
+	if err := http.ListenAndServe(":"+p.RedirectPort, handler); err != nil {
+		log.Printf("HTTP跳转服务启动失败: %v", err)
+	}
+}
+
+// 设置登录cookie,前端不能读取,启用HTTPS时只通过HTTPS发送
+func setAuthCookie(c *gin.Context, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie("cookie", value, maxAge, cookiePath(), "", tlsEnabled, true)
+}
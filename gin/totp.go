@@ -0,0 +1,367 @@
+package serve
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+	"xuanwu/config"
+	r "xuanwu/gin/response"
+	"xuanwu/lib"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	totpIssuer         = "XuanWu"
+	totpSetupTTL       = 10 * time.Minute // 扫码后需在此时间内确认开启
+	totpTicketTTL      = 5 * time.Minute  // 密码验证通过后需在此时间内输入验证码
+	totpTicketAttempts = 5                // 每次登录最多尝试输入验证码的次数
+	recoveryCodeCount  = 10
+)
+
+var errTOTPCode = errors.New("验证码错误")
+
+// 待确认的两步验证密钥
+type totpPending struct {
+	secret  string
+	expires time.Time
+}
+
+// 已通过密码验证,等待输入验证码的登录
+type totpTicket struct {
+	user     string
+	expires  time.Time
+	attempts int
+}
+
+// 两步验证的临时状态,只保存在内存中,重启后需要重新操作
+type totpState struct {
+	mu       sync.Mutex
+	pending  map[string]totpPending
+	tickets  map[string]*totpTicket
+	lastStep map[string]int64 // 每个用户最近一次使用的验证码周期,防止重放
+}
+
+var totp = &totpState{
+	pending:  map[string]totpPending{},
+	tickets:  map[string]*totpTicket{},
+	lastStep: map[string]int64{},
+}
+
+// 清理过期数据,调用方需持有锁
+func (s *totpState) clean(now time.Time) {
+	for user, p := range s.pending {
+		if now.After(p.expires) {
+			delete(s.pending, user)
+		}
+	}
+	for id, t := range s.tickets {
+		if now.After(t.expires) {
+			delete(s.tickets, id)
+		}
+	}
+}
+
+// 生成新的待确认密钥
+func (s *totpState) setup(user string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.clean(now)
+	secret := lib.GenerateTOTPSecret()
+	s.pending[user] = totpPending{secret: secret, expires: now.Add(totpSetupTTL)}
+	return secret
+}
+
+// 取出待确认的密钥
+func (s *totpState) pendingSecret(user string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clean(time.Now())
+	p, ok := s.pending[user]
+	return p.secret, ok
+}
+
+// 开启后删除待确认的密钥
+func (s *totpState) clearPending(user string) {
+	s.mu.Lock()
+	delete(s.pending, user)
+	s.mu.Unlock()
+}
+
+// 创建登录票据
+func (s *totpState) newTicket(user string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.clean(now)
+	id := lib.RandomString(32)
+	s.tickets[id] = &totpTicket{user: user, expires: now.Add(totpTicketTTL)}
+	return id
+}
+
+// 使用登录票据,超过尝试次数后票据作废
+func (s *totpState) useTicket(id string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clean(time.Now())
+	t, ok := s.tickets[id]
+	if !ok {
+		return "", false
+	}
+	t.attempts++
+	if t.attempts > totpTicketAttempts {
+		delete(s.tickets, id)
+		return "", false
+	}
+	return t.user, true
+}
+
+// 登录完成后删除票据
+func (s *totpState) finishTicket(id string) {
+	s.mu.Lock()
+	delete(s.tickets, id)
+	s.mu.Unlock()
+}
+
+// 校验验证码,同一周期的验证码只能使用一次
+func (s *totpState) verify(user, secret, code string) bool {
+	step, ok := lib.VerifyTOTP(secret, code, time.Now())
+	if !ok {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if step <= s.lastStep[user] {
+		return false
+	}
+	s.lastStep[user] = step
+	return true
+}
+
+// 生成恢复码,返回明文和SHA256值
+func newRecoveryCodes() ([]string, []string) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw := lib.RandomString(5)
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashes[i] = lib.SHA256(codes[i])
+	}
+	return codes, hashes
+}
+
+// 校验验证码或恢复码,恢复码使用后删除
+func verifySecondFactor(username, code string) error {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if code == "" {
+		return errTOTPCode
+	}
+	user, ok := currentUser(username)
+	if !ok || user.Disabled || !user.TOTPEnabled() {
+		return errUserNotFound
+	}
+	if totp.verify(username, user.TOTPSecret, code) {
+		return nil
+	}
+	if !strings.Contains(code, "-") {
+		return errTOTPCode
+	}
+	hash := lib.SHA256(code)
+	return updateUser(username, username, func(u *config.User) error {
+		for i, h := range u.RecoveryCodes {
+			if h == hash {
+				u.RecoveryCodes = append(u.RecoveryCodes[:i], u.RecoveryCodes[i+1:]...)
+				return nil
+			}
+		}
+		return errTOTPCode
+	})
+}
+
+// 校验当前用户的密码,密码为SHA256值
+func checkCurrentPassword(c *gin.Context, password string) (config.User, bool) {
+	user, ok := currentUser(c.GetString(r.UserKey))
+	if !ok || password == "" {
+		return user, false
+	}
+	ok, _ = lib.VerifyPassword(user.Password, password)
+	return user, ok
+}
+
+// LoginTOTPHandle 登录第二步,使用验证码或恢复码完成登录
+func (p *ApiData) LoginTOTPHandle(c *gin.Context) {
+	var req struct {
+		Ticket string `json:"ticket"`
+		Code   string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Ticket == "" {
+		r.ErrMesage(c, "请求参数错误")
+		return
+	}
+	username, ok := totp.useTicket(req.Ticket)
+	if !ok {
+		r.ErrMesage(c, "登录已过期,请重新输入密码")
+		return
+	}
+	ip := c.ClientIP()
+	if msg, ok := loginLimit.Check(ip, username); !ok {
+		r.ErrMesage(c, msg)
+		return
+	}
+	if err := verifySecondFactor(username, req.Code); err != nil {
+		loginLimit.Fail(ip, username)
+		r.ErrMesage(c, errTOTPCode.Error())
+		return
+	}
+	totp.finishTicket(req.Ticket)
+	loginLimit.Success(username)
+
+	user, ok := currentUser(username)
+	if !ok {
+		r.ErrMesage(c, "登录失败")
+		return
+	}
+	issueLogin(c, user)
+}
+
+// HandlerTOTPStatus 获取当前用户的两步验证状态
+func HandlerTOTPStatus(c *gin.Context) {
+	user, ok := currentUser(c.GetString(r.UserKey))
+	if !ok {
+		r.ErrMesage(c, "获取用户信息失败")
+		return
+	}
+	r.OkData(c, gin.H{
+		"enabled":        user.TOTPEnabled(),
+		"recovery_codes": len(user.RecoveryCodes), // 剩余恢复码数量
+	})
+}
+
+// HandlerTOTPSetup 生成两步验证密钥和二维码,确认后才会开启
+func HandlerTOTPSetup(c *gin.Context) {
+	user, ok := currentUser(c.GetString(r.UserKey))
+	if !ok {
+		r.ErrMesage(c, "获取用户信息失败")
+		return
+	}
+	if user.TOTPEnabled() {
+		r.ErrMesage(c, "已开启两步验证")
+		return
+	}
+
+	secret := totp.setup(user.Username)
+	uri := lib.TOTPURI(totpIssuer, user.Username, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		r.ErrMesage(c, "生成二维码失败")
+		return
+	}
+	r.OkData(c, gin.H{
+		"secret": secret,
+		"uri":    uri,
+		"qrcode": "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	})
+}
+
+// HandlerTOTPEnable 输入验证码确认开启两步验证,返回恢复码
+func HandlerTOTPEnable(c *gin.Context) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		r.ErrMesage(c, "请求参数错误")
+		return
+	}
+	username := c.GetString(r.UserKey)
+	secret, ok := totp.pendingSecret(username)
+	if !ok {
+		r.ErrMesage(c, "请重新获取二维码")
+		return
+	}
+	if !totp.verify(username, secret, req.Code) {
+		r.ErrMesage(c, errTOTPCode.Error())
+		return
+	}
+
+	codes, hashes := newRecoveryCodes()
+	err := updateUser(username, username, func(u *config.User) error {
+		u.TOTPSecret = secret
+		u.RecoveryCodes = hashes
+		return nil
+	})
+	if err != nil {
+		r.ErrMesage(c, "保存失败")
+		return
+	}
+	totp.clearPending(username)
+
+	r.OkMesageData(c, "已开启两步验证,恢复码只显示一次,请妥善保存", gin.H{
+		"recovery_codes": codes,
+	})
+}
+
+// HandlerTOTPDisable 关闭两步验证,需要输入密码
+func HandlerTOTPDisable(c *gin.Context) {
+	var req struct {
+		Password string `json:"password"` // SHA256值
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		r.ErrMesage(c, "请求参数错误")
+		return
+	}
+	user, ok := checkCurrentPassword(c, req.Password)
+	if !ok {
+		r.ErrMesage(c, "密码错误")
+		return
+	}
+	if !user.TOTPEnabled() {
+		r.ErrMesage(c, "未开启两步验证")
+		return
+	}
+	err := updateUser(user.Username, user.Username, func(u *config.User) error {
+		u.TOTPSecret = ""
+		u.RecoveryCodes = nil
+		return nil
+	})
+	if err != nil {
+		r.ErrMesage(c, "保存失败")
+		return
+	}
+	r.OkMesage(c, "已关闭两步验证")
+}
+
+// HandlerTOTPRecoveryCodes 重新生成恢复码,需要输入密码,旧恢复码失效
+func HandlerTOTPRecoveryCodes(c *gin.Context) {
+	var req struct {
+		Password string `json:"password"` // SHA256值
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		r.ErrMesage(c, "请求参数错误")
+		return
+	}
+	user, ok := checkCurrentPassword(c, req.Password)
+	if !ok {
+		r.ErrMesage(c, "密码错误")
+		return
+	}
+	if !user.TOTPEnabled() {
+		r.ErrMesage(c, "未开启两步验证")
+		return
+	}
+	codes, hashes := newRecoveryCodes()
+	err := updateUser(user.Username, user.Username, func(u *config.User) error {
+		u.RecoveryCodes = hashes
+		return nil
+	})
+	if err != nil {
+		r.ErrMesage(c, "保存失败")
+		return
+	}
+	r.OkMesageData(c, "恢复码已重新生成,只显示一次,请妥善保存", gin.H{
+		"recovery_codes": codes,
+	})
+}
@@ -0,0 +1,120 @@
+package serve
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+	"xuanwu/lib/pathutil"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+const uiReloadInterval = time.Second // 开发模式检查文件变化的间隔
+
+// 前端文件,磁盘目录中的文件优先于嵌入的文件
+type staticUI struct {
+	files  atomic.Pointer[staticFiles]
+	dir    string // 为空表示只使用嵌入的文件
+	dev    bool
+	layers []fs.FS
+}
+
+// 获取前端目录,配置 ui_dir 时使用该目录(相对路径以数据目录为基准),否则存在 data/ui 时使用
+func uiDir(cfg gjson.Result) (string, error) {
+	dir := cfg.Get("ui_dir").String()
+	if dir == "" {
+		dir = pathutil.GetDataPath(pathutil.UI_DIR)
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return "", nil
+		}
+		return dir, nil
+	}
+	if !filepath.IsAbs(dir) {
+		dir = pathutil.GetDataPath(dir)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("ui_dir 不存在: %s", dir)
+	}
+	return dir, nil
+}
+
+// 加载前端文件,ui_dev 为 true 时目录中的文件变化后自动重新加载
+func loadUI(cfg gjson.Result, embedded fs.FS) (*staticUI, error) {
+	u := &staticUI{dev: cfg.Get("ui_dev").Bool()}
+	dir, err := uiDir(cfg)
+	if err != nil {
+		log.Printf("%v,使用内置前端", err)
+	}
+	u.dir = dir
+	u.layers = []fs.FS{embedded}
+	if dir != "" {
+		u.layers = append(u.layers, os.DirFS(dir))
+		fmt.Println("前端目录：" + dir)
+		log.Printf("使用前端目录: %s", dir)
+	}
+	if err := u.reload(); err != nil {
+		return nil, err
+	}
+	if u.dev && dir != "" {
+		go u.watch()
+	}
+	return u, nil
+}
+
+// 重新读取所有文件
+func (u *staticUI) reload() error {
+	s, err := loadStatic(u.layers...)
+	if err != nil {
+		return err
+	}
+	s.noCache = u.dev
+	u.files.Store(s)
+	return nil
+}
+
+// 目录的文件数量、总大小和最后修改时间,用于判断是否变化
+func (u *staticUI) snapshot() string {
+	var count, size int64
+	var latest time.Time
+	filepath.WalkDir(u.dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			count++
+			size += info.Size()
+			if info.ModTime().After(latest) {
+				latest = info.ModTime()
+			}
+		}
+		return nil
+	})
+	return fmt.Sprintf("%d/%d/%d", count, size, latest.UnixNano())
+}
+
+// 开发模式下定时检查目录,变化后重新加载
+func (u *staticUI) watch() {
+	last := u.snapshot()
+	for range time.Tick(uiReloadInterval) {
+		current := u.snapshot()
+		if current == last {
+			continue
+		}
+		last = current
+		if err := u.reload(); err != nil {
+			log.Printf("重新加载前端文件失败: %v", err)
+			continue
+		}
+		log.Printf("前端文件已重新加载: %s", u.dir)
+	}
+}
+
+// Handler 使用当前加载的文件处理请求
+func (u *staticUI) Handler(c *gin.Context) {
+	u.files.Load().Handler(c)
+}
@@ -0,0 +1,223 @@
+package serve
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"unicode/utf8"
+	"xuanwu/config"
+	r "xuanwu/gin/response"
+	"xuanwu/lib"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+var (
+	errUserNotFound = errors.New("用户不存在")
+	errLastAdmin    = errors.New("至少需要保留一个可用的管理员")
+)
+
+// 按用户名获取用户
+func currentUser(username string) (config.User, bool) {
+	cfg, err := config.ReadConfigFileToJson()
+	if err != nil {
+		log.Println("读取配置文件出错")
+		return config.User{}, false
+	}
+	return config.FindUser(cfg, username)
+}
+
+// 修改用户列表并保存配置,operator为修订记录中的操作用户
+// 读取和保存在同一个保存锁内完成
+func saveUsers(operator string, fn func(users []config.User) ([]config.User, error)) error {
+	return config.UpdateConfig(operator, func(cfg gjson.Result) ([]byte, error) {
+		users, err := fn(config.GetUsers(cfg))
+		if err != nil {
+			return nil, err
+		}
+		if config.CountAdmins(users) == 0 {
+			return nil, errLastAdmin
+		}
+		jsonStr, err := config.SetUsers(cfg.Raw, users)
+		return []byte(jsonStr), err
+	})
+}
+
+// 修改指定用户并保存配置
+func updateUser(username, operator string, fn func(u *config.User) error) error {
+	return saveUsers(operator, func(users []config.User) ([]config.User, error) {
+		for i := range users {
+			if users[i].Username == username {
+				return users, fn(&users[i])
+			}
+		}
+		return nil, errUserNotFound
+	})
+}
+
+// 检查用户名格式
+func validUsername(name string) bool {
+	return name != "" && utf8.ValidString(name) && strings.TrimSpace(name) == name
+}
+
+// 返回给前端的用户信息,不包含密码
+type userInfo struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	Disabled bool   `json:"disabled"`
+	TOTP     bool   `json:"totp"` // 是否开启两步验证
+}
+
+// HandlerUserList 获取用户列表
+func HandlerUserList(c *gin.Context) {
+	cfg, err := config.ReadConfigFileToJson()
+	if err != nil {
+		r.ErrMesage(c, "读取配置文件失败")
+		return
+	}
+	list := []userInfo{}
+	for _, u := range config.GetUsers(cfg) {
+		list = append(list, userInfo{Username: u.Username, Role: u.Role, Disabled: u.Disabled, TOTP: u.TOTPEnabled()})
+	}
+	r.OkData(c, list)
+}
+
+// HandlerUserAdd 添加用户,密码为SHA256值
+func HandlerUserAdd(c *gin.Context) {
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Role     string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		r.ErrMesage(c, "请求参数错误")
+		return
+	}
+	if !validUsername(req.Username) {
+		r.ErrMesage(c, "用户名格式错误")
+		return
+	}
+	// 启用单点登录时可以不设置密码,该用户只能通过单点登录
+	if req.Password == "" && !ssoEnabled() {
+		r.ErrMesage(c, "密码不能为空")
+		return
+	}
+	if !config.IsValidRole(req.Role) {
+		r.ErrMesage(c, "角色无效")
+		return
+	}
+
+	var hash string
+	if req.Password != "" {
+		var err error
+		if hash, err = lib.HashPassword(req.Password); err != nil {
+			r.ErrMesage(c, "密码加密失败")
+			return
+		}
+	}
+	err := saveUsers(c.GetString(r.UserKey), func(users []config.User) ([]config.User, error) {
+		for _, u := range users {
+			if u.Username == req.Username {
+				return nil, errors.New("用户已存在")
+			}
+		}
+		return append(users, config.User{Username: req.Username, Password: hash, Role: req.Role}), nil
+	})
+	if err != nil {
+		r.ErrMesage(c, err.Error())
+		return
+	}
+	r.OkMesage(c, "添加成功")
+}
+
+// HandlerUserUpdate 修改用户角色、密码、禁用状态或关闭两步验证
+func HandlerUserUpdate(c *gin.Context) {
+	var req struct {
+		Username  string `json:"username"`
+		Password  string `json:"password"`
+		Role      string `json:"role"`
+		Disabled  *bool  `json:"disabled"`
+		ResetTOTP bool   `json:"reset_totp"` // 关闭两步验证,用于用户丢失验证器时
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Username == "" {
+		r.ErrMesage(c, "请求参数错误")
+		return
+	}
+	if req.Role != "" && !config.IsValidRole(req.Role) {
+		r.ErrMesage(c, "角色无效")
+		return
+	}
+
+	var hash string
+	if req.Password != "" {
+		var err error
+		if hash, err = lib.HashPassword(req.Password); err != nil {
+			r.ErrMesage(c, "密码加密失败")
+			return
+		}
+	}
+
+	err := updateUser(req.Username, c.GetString(r.UserKey), func(u *config.User) error {
+		if hash != "" {
+			u.Password = hash
+		}
+		if req.Role != "" {
+			u.Role = req.Role
+		}
+		if req.Disabled != nil {
+			u.Disabled = *req.Disabled
+		}
+		if req.ResetTOTP {
+			u.TOTPSecret = ""
+			u.RecoveryCodes = nil
+		}
+		return nil
+	})
+	if err != nil {
+		r.ErrMesage(c, err.Error())
+		return
+	}
+
+	// 修改密码或禁用后该用户需要重新登录
+	if hash != "" || (req.Disabled != nil && *req.Disabled) {
+		sessions.RevokeUser(req.Username)
+	}
+	r.OkMesage(c, "修改成功")
+}
+
+// HandlerUserDelete 删除用户
+func HandlerUserDelete(c *gin.Context) {
+	var req struct {
+		Username string `json:"username"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Username == "" {
+		r.ErrMesage(c, "请求参数错误")
+		return
+	}
+	if req.Username == c.GetString(r.UserKey) {
+		r.ErrMesage(c, "不能删除当前登录的用户")
+		return
+	}
+
+	err := saveUsers(c.GetString(r.UserKey), func(users []config.User) ([]config.User, error) {
+		for i, u := range users {
+			if u.Username == req.Username {
+				return append(users[:i], users[i+1:]...), nil
+			}
+		}
+		return nil, errUserNotFound
+	})
+	if err != nil {
+		r.ErrMesage(c, err.Error())
+		return
+	}
+	// 用户删除成功后再删除令牌和会话,删除被拒绝或保存失败时保持不变
+	sessions.RevokeUser(req.Username)
+	if err := apiTokens.RevokeUser(req.Username); err != nil {
+		log.Printf("删除用户的访问令牌失败: %v", err)
+		r.ErrMesage(c, "用户已删除，删除访问令牌失败")
+		return
+	}
+	r.OkMesage(c, "删除成功")
+}
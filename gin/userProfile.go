@@ -2,32 +2,27 @@ package serve
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"xuanwu/config"
 	r "xuanwu/gin/response"
 	"xuanwu/lib"
-	"xuanwu/lib/pathutil"
 	"xuanwu/xuanwu"
 
 	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
 	"github.com/tidwall/sjson"
 )
 
 // UserProfile 用户配置结构体
 type UserProfile struct {
 	Username         string `json:"username,omitempty"`          // 用户名
-	Password         string `json:"password,omitempty"`          // 密码(SHA256)
+	Password         string `json:"password,omitempty"`          // 密码(SHA256),服务端再用argon2id哈希保存
 	OldPassword      string `json:"old_password,omitempty"`      // 旧密码(SHA256)
 	CookieExpireDays int    `json:"cookie_expire_days,omitempty"` // Cookie过期天数
 	LogCleanDays     int    `json:"log_clean_days,omitempty"`     // 日志清理天数
 }
 
-// UserInfo 用户基本信息
-type UserInfo struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
-}
-
 // 全局配置缓存
 var (
 	globalCookieExpireDays = 30 // 默认30天
@@ -48,6 +43,15 @@ func InitGlobalConfig() {
 	if days := cfg.Get("log_clean_days").Int(); days > 0 {
 		globalLogCleanDays = int(days)
 	}
+
+	loginLimit.SetConfig(cfg.Get("login_limit"))
+	loadHealth(cfg)
+	if err := loadMetrics(cfg); err != nil {
+		log.Printf("指标配置错误: %v", err)
+	}
+	if err := loadSSO(cfg); err != nil {
+		log.Printf("单点登录配置错误: %v", err)
+	}
 }
 
 // GetCookieExpireDays 获取当前Cookie过期天数
@@ -60,23 +64,6 @@ func GetLogCleanDays() int {
 	return globalLogCleanDays
 }
 
-/* 从json文件中获取用户信息 公共方法 */
-func GetUserInfo() UserInfo {
-	cfg, err := config.ReadConfigFileToJson()
-	if err != nil {
-		log.Println("读取配置文件出错")
-		return UserInfo{}
-	}
-	userInfo := UserInfo{
-		Username: cfg.Get("username").String(),
-		Password: cfg.Get("password").String(),
-	}
-	if userInfo.Username == "" { //没有查到用户数据
-		return UserInfo{}
-	}
-	return userInfo
-}
-
 // HandlerGetUserProfile 获取用户配置
 func (p *ApiData) HandlerGetUserProfile(c *gin.Context) {
 	cfg, err := config.ReadConfigFileToJson()
@@ -85,19 +72,20 @@ func (p *ApiData) HandlerGetUserProfile(c *gin.Context) {
 		return
 	}
 
+	user, ok := config.FindUser(cfg, c.GetString(r.UserKey))
+	if !ok {
+		r.ErrMesage(c, "获取用户信息失败")
+		return
+	}
 	profile := UserProfile{
-		Username:         cfg.Get("username").String(),
+		Username:         user.Username,
 		CookieExpireDays: int(cfg.Get("cookie_expire_days").Int()),
 		LogCleanDays:     int(cfg.Get("log_clean_days").Int()),
 	}
 
-	if profile.Username == "" {
-		r.ErrMesage(c, "获取用户信息失败")
-		return
-	}
-
 	r.OkData(c, gin.H{
 		"profile": profile,
+		"role":    user.Role,
 		"version": config.Version,
 		"is_windows": config.IsWindows,
 	})
@@ -138,73 +126,105 @@ func (p *ApiData) HandlerUpdateUserProfile(c *gin.Context) {
 		return
 	}
 
-	cfg, err := config.ReadConfigFileToJson()
-	if err != nil {
-		r.ErrMesage(c, "读取配置文件失败")
+	// 系统设置只有管理员可以修改
+	if (req.CookieExpireDays > 0 || req.LogCleanDays > 0) && !HasPermission(c, PERM_ADMIN) {
+		r.ErrMesage(c, "没有操作权限")
 		return
 	}
 
-	jsonStr := cfg.Raw
 	needResetToken := false
+	username := c.GetString(r.UserKey)
+	renamed := false
+
+	// 在保存锁内读取并修改配置,用户名修改时访问令牌随配置一起改到新用户名下
+	err := config.UpdateConfig(username, func(cfg gjson.Result) ([]byte, error) {
+		jsonStr := cfg.Raw
+		users := config.GetUsers(cfg)
+		index := -1
+		for i := range users {
+			if users[i].Username == username {
+				index = i
+			}
+		}
+		if index < 0 {
+			return nil, errors.New("获取用户信息失败")
+		}
 
-	// 更新用户名
-	if req.Username != "" {
-		// 验证用户名加解密
-		encryptedUsername, _ := lib.EncryptByAes([]byte(req.Username))
-		decryptedUsername, err := lib.DecryptByAes(encryptedUsername)
-		if err != nil || string(decryptedUsername) != req.Username {
-			r.ErrMesage(c, "用户名格式错误")
-			return
+		// 更新用户名
+		if req.Username != "" && req.Username != username {
+			// 验证用户名格式
+			if !validUsername(req.Username) {
+				return nil, errors.New("用户名格式错误")
+			}
+			if _, exists := config.FindUser(cfg, req.Username); exists {
+				return nil, errors.New("用户名已存在")
+			}
+			users[index].Username = req.Username
+			needResetToken = true
 		}
-		jsonStr, _ = sjson.Set(jsonStr, "username", req.Username)
-		needResetToken = true
-	}
 
-	// 更新密码
-	if req.Password != "" && req.OldPassword != "" {
-		currentPass := cfg.Get("password").String()
-		if currentPass != req.OldPassword {
-			r.ErrMesage(c, "旧密码错误")
-			return
+		// 更新密码
+		if req.Password != "" && req.OldPassword != "" {
+			if ok, _ := lib.VerifyPassword(users[index].Password, req.OldPassword); !ok {
+				return nil, errors.New("旧密码错误")
+			}
+			if req.Password == req.OldPassword {
+				return nil, errors.New("新密码不能与旧密码相同")
+			}
+			hash, err := lib.HashPassword(req.Password)
+			if err != nil {
+				return nil, errors.New("密码加密失败")
+			}
+			users[index].Password = hash
+			needResetToken = true
+		} else if req.Password != "" {
+			return nil, errors.New("请提供旧密码")
 		}
 
-		if req.Password == req.OldPassword {
-			r.ErrMesage(c, "新密码不能与旧密码相同")
-			return
+		if needResetToken {
+			jsonStr, _ = config.SetUsers(jsonStr, users)
+		}
+		// 更新Cookie过期天数
+		if req.CookieExpireDays > 0 {
+			jsonStr, _ = sjson.Set(jsonStr, "cookie_expire_days", req.CookieExpireDays)
+		}
+		// 更新日志清理天数
+		if req.LogCleanDays > 0 {
+			jsonStr, _ = sjson.Set(jsonStr, "log_clean_days", req.LogCleanDays)
 		}
 
-		jsonStr, _ = sjson.Set(jsonStr, "password", req.Password)
-		needResetToken = true
-	} else if req.Password != "" {
-		r.ErrMesage(c, "请提供旧密码")
+		if users[index].Username != username {
+			if err := apiTokens.RenameUser(username, users[index].Username); err != nil {
+				log.Printf("修改访问令牌的用户名失败: %v", err)
+				return nil, errors.New("配置文件写入失败")
+			}
+			renamed = true
+		}
+		return []byte(jsonStr), nil
+	})
+	if err != nil {
+		// 配置未保存时令牌改回原用户名
+		if renamed {
+			apiTokens.RenameUser(req.Username, username)
+		}
+		r.ErrMesage(c, err.Error())
 		return
 	}
 
-	// 更新Cookie过期天数
 	if req.CookieExpireDays > 0 {
-		jsonStr, _ = sjson.Set(jsonStr, "cookie_expire_days", req.CookieExpireDays)
 		globalCookieExpireDays = req.CookieExpireDays
 	}
-
-	// 更新日志清理天数
 	if req.LogCleanDays > 0 {
-		jsonStr, _ = sjson.Set(jsonStr, "log_clean_days", req.LogCleanDays)
 		globalLogCleanDays = req.LogCleanDays
 		// 更新系统任务中的清理天数
 		xuanwu.UpdateLogCleanDays(req.LogCleanDays)
 	}
 
-	// 写入配置文件
-	configPath := pathutil.GetConfigPath()
-	if err := config.WriteConfigFile(configPath, []byte(jsonStr)); err != nil {
-		r.ErrMesage(c, "配置文件写入失败")
-		return
-	}
-
-	// 如果修改了用户名或密码，强制用户重新登录
+	// 如果修改了用户名或密码，强制该用户所有会话重新登录
 	if needResetToken {
 		p.ClearUserToken(c)
+		sessions.RevokeUser(username)
 	}
 
 	r.OkMesage(c, "更新成功")
-} 
\ No newline at end of file
+}
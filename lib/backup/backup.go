@@ -0,0 +1,362 @@
+package backup
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"xuanwu/config"
+	"xuanwu/lib/pathutil"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	FORMAT_TARGZ = "tar.gz"
+	FORMAT_ZIP   = "zip"
+
+	BACKUP_DIR  = "backups"          // 定时备份存放目录
+	STAGING_DIR = ".restore-staging" // 恢复时的暂存目录
+
+	maxRestoreSize = 4 << 30 // 恢复时解压后的总大小上限
+)
+
+// Options 备份选项
+type Options struct {
+	Format      string
+	ExcludeLogs bool // 不包含日志目录
+}
+
+// 备份时始终排除的文件和目录
+var alwaysExclude = map[string]bool{
+	BACKUP_DIR:                      true,
+	STAGING_DIR:                     true,
+	pathutil.INSTANCE_LOCK:          true,
+	pathutil.CONFIG_LOCK:            true,
+	config.SQLITE_FILE + "-wal":     true,
+	config.SQLITE_FILE + "-shm":     true,
+	config.SQLITE_FILE + "-journal": true,
+}
+
+// NormalizeFormat 规范化格式名称,不支持时返回空字符串
+func NormalizeFormat(format string) string {
+	switch strings.ToLower(format) {
+	case "", "tar.gz", "tgz", "targz":
+		return FORMAT_TARGZ
+	case "zip":
+		return FORMAT_ZIP
+	}
+	return ""
+}
+
+// FileName 生成备份文件名
+func FileName(format string) string {
+	return fmt.Sprintf("xuanwu-%s.%s", time.Now().Format("20060102-150405"), NormalizeFormat(format))
+}
+
+// 备份的文件
+type entry struct {
+	name string // 归档内的相对路径
+	path string // 磁盘上的路径
+	info fs.FileInfo
+}
+
+// 收集数据目录下需要备份的文件,SQLite数据库使用快照代替
+func collect(opts Options) ([]entry, func(), error) {
+	dataDir := pathutil.GetDataPath("")
+	cleanup := func() {}
+
+	var entries []entry
+	err := filepath.Walk(dataDir, func(p string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dataDir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		top := strings.SplitN(rel, "/", 2)[0]
+		if alwaysExclude[top] || (opts.ExcludeLogs && top == pathutil.LOG_DIR) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// 跳过socket等特殊文件
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+		entries = append(entries, entry{name: rel, path: p, info: info})
+		return nil
+	})
+	if err != nil {
+		return nil, cleanup, err
+	}
+
+	// 临时文件在打包完成后删除
+	var temps []string
+	cleanup = func() {
+		for _, tmp := range temps {
+			os.Remove(tmp)
+		}
+	}
+	replace := func(name, tmp string) error {
+		info, err := os.Stat(tmp)
+		if err != nil {
+			return err
+		}
+		for i := range entries {
+			if entries[i].name == name {
+				entries[i].path = tmp
+				entries[i].info = info
+			}
+		}
+		return nil
+	}
+
+	if snap, ok := config.GetStore().(config.Snapshotter); ok {
+		tmp := filepath.Join(os.TempDir(), fmt.Sprintf("xuanwu-snapshot-%d.db", time.Now().UnixNano()))
+		temps = append(temps, tmp, tmp+"-wal", tmp+"-shm")
+		if err := snap.Snapshot(tmp); err != nil {
+			return nil, cleanup, fmt.Errorf("生成数据库快照失败: %v", err)
+		}
+		if err := config.ScrubSQLiteFile(tmp); err != nil {
+			return nil, cleanup, fmt.Errorf("清理数据库快照失败: %v", err)
+		}
+		if err := replace(config.SQLITE_FILE, tmp); err != nil {
+			return nil, cleanup, err
+		}
+	}
+
+	// 配置文件和修订记录使用去掉敏感信息后的副本
+	for _, e := range entries {
+		fn, ok := sanitizers[e.name]
+		if !ok {
+			continue
+		}
+		data, err := os.ReadFile(e.path)
+		if err != nil {
+			return nil, cleanup, err
+		}
+		if data, err = fn(data); err != nil {
+			return nil, cleanup, fmt.Errorf("处理 %s 失败: %v", e.name, err)
+		}
+		tmp := filepath.Join(os.TempDir(), fmt.Sprintf("xuanwu-backup-%d-%s", time.Now().UnixNano(), path.Base(e.name)))
+		temps = append(temps, tmp)
+		if err := os.WriteFile(tmp, data, 0600); err != nil {
+			return nil, cleanup, err
+		}
+		if err := replace(e.name, tmp); err != nil {
+			return nil, cleanup, err
+		}
+	}
+
+	return entries, cleanup, nil
+}
+
+// 打包前需要处理的文件
+// config.json 中的旧版SHA256密码改为argon2id哈希,恢复后仍可登录;其他副本直接去掉密码等字段
+var sanitizers = map[string]func([]byte) ([]byte, error){
+	pathutil.CONFIG_FILE: config.RehashLegacyPasswords,
+	pathutil.CONFIG_FILE + ".bak": func(data []byte) ([]byte, error) {
+		return config.StripSecrets(data), nil
+	},
+	config.REVISION_FILE: stripRevisionLines,
+}
+
+// 去掉每条修订记录完整配置中的敏感字段
+func stripRevisionLines(data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if raw := gjson.GetBytes(line, "data"); raw.Exists() {
+			stripped := config.StripSecrets([]byte(raw.Raw))
+			line, _ = sjson.SetRawBytes(line, "data", stripped)
+		}
+		out.Write(line)
+		out.WriteByte('\n')
+	}
+	return out.Bytes(), scanner.Err()
+}
+
+// Write 将数据目录打包写入w
+func Write(w io.Writer, opts Options) error {
+	entries, cleanup, err := collect(opts)
+	defer cleanup()
+	if err != nil {
+		return err
+	}
+
+	switch NormalizeFormat(opts.Format) {
+	case FORMAT_ZIP:
+		return writeZip(w, entries)
+	case FORMAT_TARGZ:
+		return writeTarGz(w, entries)
+	}
+	return fmt.Errorf("不支持的备份格式: %s", opts.Format)
+}
+
+func writeTarGz(w io.Writer, entries []entry) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	for _, e := range entries {
+		hdr, err := tar.FileInfoHeader(e.info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = e.name
+		if e.info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if e.info.IsDir() {
+			continue
+		}
+		if err := copyFile(tw, e.path); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func writeZip(w io.Writer, entries []entry) error {
+	zw := zip.NewWriter(w)
+
+	for _, e := range entries {
+		hdr, err := zip.FileInfoHeader(e.info)
+		if err != nil {
+			return err
+		}
+		hdr.Name = e.name
+		if e.info.IsDir() {
+			hdr.Name += "/"
+		} else {
+			hdr.Method = zip.Deflate
+		}
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if e.info.IsDir() {
+			continue
+		}
+		if err := copyFile(fw, e.path); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func copyFile(w io.Writer, p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// CreateFile 在备份目录生成备份文件,返回文件路径
+func CreateFile(opts Options) (string, error) {
+	dir := pathutil.GetDataPath(BACKUP_DIR)
+	if err := pathutil.EnsureDir(dir); err != nil {
+		return "", err
+	}
+
+	dst := filepath.Join(dir, FileName(opts.Format))
+	f, err := os.Create(dst + ".tmp")
+	if err != nil {
+		return "", err
+	}
+	bw := bufio.NewWriter(f)
+	if err := Write(bw, opts); err != nil {
+		f.Close()
+		os.Remove(dst + ".tmp")
+		return "", err
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		os.Remove(dst + ".tmp")
+		return "", err
+	}
+	f.Close()
+	return dst, os.Rename(dst+".tmp", dst)
+}
+
+// Prune 只保留最新的keep个备份文件
+func Prune(keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	dir := pathutil.GetDataPath(BACKUP_DIR)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for _, f := range files {
+		name := f.Name()
+		if !f.IsDir() && strings.HasPrefix(name, "xuanwu-") &&
+			(strings.HasSuffix(name, "."+FORMAT_TARGZ) || strings.HasSuffix(name, "."+FORMAT_ZIP)) {
+			names = append(names, name)
+		}
+	}
+	// 文件名包含时间,按名称倒序即按时间倒序
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+
+	for i := keep; i < len(names); i++ {
+		if err := os.Remove(filepath.Join(dir, names[i])); err != nil {
+			log.Printf("删除旧备份失败[%s]: %v", names[i], err)
+		} else {
+			log.Printf("已删除旧备份: %s", names[i])
+		}
+	}
+	return nil
+}
+
+// 检查归档内的路径,返回清理后的相对路径,需要跳过时返回空字符串
+func cleanName(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || filepath.IsAbs(name) {
+		return "", fmt.Errorf("非法路径: %s", name)
+	}
+	for _, seg := range strings.Split(name, "/") {
+		if seg == ".." {
+			return "", fmt.Errorf("非法路径: %s", name)
+		}
+	}
+	name = path.Clean(name)
+	if name == "." {
+		return "", nil
+	}
+	top := strings.SplitN(name, "/", 2)[0]
+	if alwaysExclude[top] {
+		return "", nil
+	}
+	return name, nil
+}
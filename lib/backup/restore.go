@@ -0,0 +1,328 @@
+package backup
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+	"xuanwu/config"
+	"xuanwu/lib/pathutil"
+
+	"github.com/tidwall/gjson"
+)
+
+var errNoConfig = errors.New("备份文件中没有 config.json 或 " + config.SQLITE_FILE)
+
+// DetectFormat 根据文件头判断归档格式
+func DetectFormat(archivePath string) (string, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(f, head); err != nil {
+		return "", fmt.Errorf("无法识别的备份文件")
+	}
+	switch {
+	case head[0] == 0x1f && head[1] == 0x8b:
+		return FORMAT_TARGZ, nil
+	case string(head) == "PK\x03\x04":
+		return FORMAT_ZIP, nil
+	}
+	return "", fmt.Errorf("无法识别的备份文件")
+}
+
+// Restore 校验并解压备份到暂存目录,然后替换数据目录中的文件
+// 替换前的文件移动到 backups/pre-restore-时间 目录,日志目录只做合并
+func Restore(archivePath string) error {
+	format, err := DetectFormat(archivePath)
+	if err != nil {
+		return err
+	}
+
+	staging := pathutil.GetDataPath(STAGING_DIR)
+	os.RemoveAll(staging)
+	defer os.RemoveAll(staging)
+
+	if format == FORMAT_ZIP {
+		err = extractZip(archivePath, staging)
+	} else {
+		err = extractTarGz(archivePath, staging)
+	}
+	if err != nil {
+		return err
+	}
+	if err := validate(staging); err != nil {
+		return err
+	}
+
+	// 替换期间关闭存储并阻止其他请求重新打开,完成后由调用方重新加载
+	return config.ReplaceDataFiles(func() error {
+		return swap(staging)
+	})
+}
+
+// 检查暂存目录中的配置是否可用
+func validate(staging string) error {
+	configPath := filepath.Join(staging, pathutil.CONFIG_FILE)
+	dbPath := filepath.Join(staging, config.SQLITE_FILE)
+	if !pathutil.IsFileExist(configPath) && !pathutil.IsFileExist(dbPath) {
+		return errNoConfig
+	}
+	if pathutil.IsFileExist(configPath) {
+		jsonByte, err := os.ReadFile(configPath)
+		if err != nil {
+			return err
+		}
+		if !gjson.ValidBytes(jsonByte) {
+			return fmt.Errorf("备份中的 config.json 格式错误")
+		}
+	}
+	if pathutil.IsFileExist(dbPath) {
+		s, err := config.OpenSQLiteStore(dbPath)
+		if err != nil {
+			return fmt.Errorf("备份中的数据库无法打开: %v", err)
+		}
+		_, err = s.Load()
+		s.Close()
+		if err != nil {
+			return fmt.Errorf("备份中的数据库无法读取: %v", err)
+		}
+	}
+	return nil
+}
+
+// 用暂存目录中的文件替换数据目录
+// 中途失败时移走已恢复的文件,并把原文件移回数据目录
+func swap(staging string) (err error) {
+	dataDir := pathutil.GetDataPath("")
+	prev := pathutil.GetDataPath(filepath.Join(BACKUP_DIR, "pre-restore-"+time.Now().Format("20060102-150405")))
+	if err := pathutil.EnsureDir(prev); err != nil {
+		return err
+	}
+
+	var moved, restored []string
+	defer func() {
+		if err != nil {
+			undoSwap(dataDir, staging, prev, moved, restored)
+		}
+	}()
+
+	current, err := os.ReadDir(dataDir)
+	if err != nil {
+		return err
+	}
+	for _, f := range current {
+		name := f.Name()
+		if alwaysExclude[name] || name == pathutil.LOG_DIR {
+			continue
+		}
+		if err := os.Rename(filepath.Join(dataDir, name), filepath.Join(prev, name)); err != nil {
+			return fmt.Errorf("移动原文件失败[%s]: %v", name, err)
+		}
+		moved = append(moved, name)
+	}
+
+	staged, err := os.ReadDir(staging)
+	if err != nil {
+		return err
+	}
+	for _, f := range staged {
+		name := f.Name()
+		if name == pathutil.LOG_DIR {
+			continue
+		}
+		if err := os.Rename(filepath.Join(staging, name), filepath.Join(dataDir, name)); err != nil {
+			return fmt.Errorf("恢复文件失败[%s]: %v", name, err)
+		}
+		restored = append(restored, name)
+	}
+	// 日志最后合并,合并后不再回退
+	mergeLogs(filepath.Join(staging, pathutil.LOG_DIR), pathutil.GetDataPath(pathutil.LOG_DIR))
+
+	log.Printf("数据已从备份恢复，原文件保存在 %s", prev)
+	return nil
+}
+
+// 恢复失败时撤销已完成的移动,原文件全部移回后删除空的pre-restore目录
+func undoSwap(dataDir, staging, prev string, moved, restored []string) {
+	for _, name := range restored {
+		if err := os.Rename(filepath.Join(dataDir, name), filepath.Join(staging, name)); err != nil {
+			log.Printf("撤销恢复失败[%s]: %v", name, err)
+		}
+	}
+	failed := false
+	for _, name := range moved {
+		if err := os.Rename(filepath.Join(prev, name), filepath.Join(dataDir, name)); err != nil {
+			log.Printf("移回原文件失败[%s]: %v", name, err)
+			failed = true
+		}
+	}
+	if failed {
+		log.Printf("部分原文件未能移回，请从 %s 手动恢复", prev)
+		return
+	}
+	os.Remove(prev)
+}
+
+// 日志文件正在使用中,只覆盖任务日志,保留当前的main.log
+func mergeLogs(src, dst string) {
+	files, err := os.ReadDir(src)
+	if err != nil {
+		return
+	}
+	pathutil.EnsureDir(dst)
+	for _, f := range files {
+		if f.IsDir() || f.Name() == "main.log" {
+			continue
+		}
+		if err := os.Rename(filepath.Join(src, f.Name()), filepath.Join(dst, f.Name())); err != nil {
+			log.Printf("恢复日志失败[%s]: %v", f.Name(), err)
+		}
+	}
+}
+
+// 只允许所有者读写的文件和目录,按第一级名称匹配
+var privateFiles = []string{
+	pathutil.SECRET_FILE,
+	pathutil.CONFIG_FILE + "*",
+	config.SESSION_FILE,
+	config.API_TOKEN_FILE,
+	config.SQLITE_FILE + "*",
+	config.REVISION_FILE,
+	config.AUDIT_FILE,
+	pathutil.TLS_DIR,
+}
+
+// 恢复后的文件权限,保留归档中记录的权限,密钥、会话等文件强制为0600
+func restoreMode(name string, mode os.FileMode) os.FileMode {
+	mode = mode.Perm()
+	if mode == 0 {
+		mode = 0644
+	}
+	top := strings.SplitN(name, "/", 2)[0]
+	for _, pattern := range privateFiles {
+		if ok, _ := path.Match(pattern, top); ok {
+			return mode & 0600
+		}
+	}
+	return mode
+}
+
+// 写入单个文件并累计大小
+func extractFile(dst string, mode os.FileMode, r io.Reader, total *int64) error {
+	if err := pathutil.EnsureDir(filepath.Dir(dst)); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// 文件已存在时 OpenFile 不会修改权限
+	if err := f.Chmod(mode); err != nil {
+		return err
+	}
+
+	n, err := io.Copy(f, io.LimitReader(r, maxRestoreSize-*total+1))
+	*total += n
+	if *total > maxRestoreSize {
+		return fmt.Errorf("备份文件过大")
+	}
+	return err
+}
+
+func extractTarGz(archivePath, staging string) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return fmt.Errorf("备份文件格式错误: %v", err)
+	}
+	tr := tar.NewReader(gr)
+
+	var total int64
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("备份文件格式错误: %v", err)
+		}
+		name, err := cleanName(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			continue
+		}
+		dst := filepath.Join(staging, filepath.FromSlash(name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := pathutil.EnsureDir(dst); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := extractFile(dst, restoreMode(name, hdr.FileInfo().Mode()), tr, &total); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("备份中包含不支持的文件类型: %s", name)
+		}
+	}
+}
+
+func extractZip(archivePath, staging string) error {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return fmt.Errorf("备份文件格式错误: %v", err)
+	}
+	defer zr.Close()
+
+	var total int64
+	for _, zf := range zr.File {
+		name, err := cleanName(zf.Name)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			continue
+		}
+		dst := filepath.Join(staging, filepath.FromSlash(name))
+		if strings.HasSuffix(zf.Name, "/") || zf.FileInfo().IsDir() {
+			if err := pathutil.EnsureDir(dst); err != nil {
+				return err
+			}
+			continue
+		}
+		if !zf.Mode().IsRegular() {
+			return fmt.Errorf("备份中包含不支持的文件类型: %s", name)
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		err = extractFile(dst, restoreMode(name, zf.Mode()), rc, &total)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package flock
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Lock 基于文件的进程间锁
+type Lock struct {
+	file *os.File
+}
+
+// New 打开锁文件,不存在时创建
+func New(path string) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Lock{file: f}, nil
+}
+
+// Acquire 在超时前反复尝试加锁
+func Acquire(path string, timeout time.Duration) (*Lock, error) {
+	l, err := New(path)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err = l.TryLock()
+		if err == nil {
+			return l, nil
+		}
+		if time.Now().After(deadline) {
+			l.file.Close()
+			return nil, err
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// File 获取锁文件,可用于写入持有者信息
+func (l *Lock) File() *os.File {
+	return l.file
+}
+
+// Release 释放锁并关闭文件
+func (l *Lock) Release() error {
+	l.unlock()
+	return l.file.Close()
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package flock
+
+import "syscall"
+
+// TryLock 尝试加排他锁,已被其他进程持有时立即返回错误
+func (l *Lock) TryLock() error {
+	return syscall.Flock(int(l.file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
+
+func (l *Lock) unlock() {
+	syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+}
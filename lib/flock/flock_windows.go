@@ -0,0 +1,17 @@
+//go:build windows
+
+package flock
+
+import "golang.org/x/sys/windows"
+
+// TryLock 尝试加排他锁,已被其他进程持有时立即返回错误
+func (l *Lock) TryLock() error {
+	ol := new(windows.Overlapped)
+	return windows.LockFileEx(windows.Handle(l.file.Fd()),
+		windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, ol)
+}
+
+func (l *Lock) unlock() {
+	ol := new(windows.Overlapped)
+	windows.UnlockFileEx(windows.Handle(l.file.Fd()), 0, 1, 0, ol)
+}
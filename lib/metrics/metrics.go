@@ -0,0 +1,209 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 标签值之间的分隔符,不会出现在正常文本中
+const labelSep = "\xff"
+
+// 同一组标签名的一系列值
+type vec struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (v vec) key(values []string) string {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s 需要 %d 个标签值", v.name, len(v.labels)))
+	}
+	return strings.Join(values, labelSep)
+}
+
+// 格式化标签,extra 为直方图的 le 等附加标签
+func (v vec) format(key string, extra ...string) string {
+	var pairs []string
+	if len(v.labels) > 0 {
+		for i, value := range strings.Split(key, labelSep) {
+			pairs = append(pairs, v.labels[i]+`="`+escape(value)+`"`)
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escape(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+// FormatFloat 按 Prometheus 格式输出数值
+func FormatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// WriteHeader 输出指标的说明和类型
+func WriteHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// Sample 单个样本,用于在采集时计算的指标
+type Sample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+// WriteSamples 输出采集时计算的指标,标签按名称排序
+func WriteSamples(w io.Writer, name, typ, help string, samples []Sample) {
+	WriteHeader(w, name, typ, help)
+	for _, s := range samples {
+		names := make([]string, 0, len(s.Labels))
+		for k := range s.Labels {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		var extra []string
+		for _, k := range names {
+			extra = append(extra, k, s.Labels[k])
+		}
+		fmt.Fprintf(w, "%s%s %s\n", name, vec{}.format("", extra...), FormatFloat(s.Value))
+	}
+}
+
+// 按标签排序后的键,保证输出顺序稳定
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// CounterVec 只增不减的计数器
+type CounterVec struct {
+	vec
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{vec: vec{name, help, labels}, values: map[string]float64{}}
+}
+
+// Inc 计数加一
+func (c *CounterVec) Inc(values ...string) {
+	key := c.key(values)
+	c.mu.Lock()
+	c.values[key]++
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) Write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	WriteHeader(w, c.name, "counter", c.help)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.format(key), FormatFloat(c.values[key]))
+	}
+}
+
+// GaugeVec 可增可减的仪表
+type GaugeVec struct {
+	vec
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{vec: vec{name, help, labels}, values: map[string]float64{}}
+}
+
+// Add 增加指定值,可以为负数
+func (g *GaugeVec) Add(delta float64, values ...string) {
+	key := g.key(values)
+	g.mu.Lock()
+	g.values[key] += delta
+	g.mu.Unlock()
+}
+
+func (g *GaugeVec) Write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	WriteHeader(w, g.name, "gauge", g.help)
+	for _, key := range sortedKeys(g.values) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.format(key), FormatFloat(g.values[key]))
+	}
+}
+
+// HistogramVec 直方图,记录分布、总和与次数
+type HistogramVec struct {
+	vec
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // 每个桶的累计次数
+	sum    float64
+	count  uint64
+}
+
+// NewHistogramVec buckets 为各个桶的上限,需要从小到大排列
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{vec: vec{name, help, labels}, buckets: buckets, series: map[string]*histogram{}}
+}
+
+// Observe 记录一次观测值
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	key := h.key(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *HistogramVec) Write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	WriteHeader(w, h.name, "histogram", h.help)
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.format(key, "le", FormatFloat(upper)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.format(key, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.format(key), FormatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.format(key), s.count)
+	}
+}
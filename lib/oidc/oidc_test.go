@@ -0,0 +1,243 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID     = "xuanwu"
+	testClientSecret = "s3cret"
+	testKid          = "test-key"
+	testCode         = "auth-code"
+	testVerifier     = "verifier-0123456789"
+	testNonce        = "nonce-abc"
+)
+
+// 模拟的OIDC提供方,token接口返回 idToken 生成的 id_token
+type testIssuer struct {
+	*httptest.Server
+	key     *rsa.PrivateKey
+	idToken func(iss string) string
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ti := &testIssuer{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 ti.URL,
+			"authorization_endpoint": ti.URL + "/authorize",
+			"token_endpoint":         ti.URL + "/token",
+			"jwks_uri":               ti.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": testKid,
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		r.ParseForm()
+		switch {
+		case id != testClientID || secret != testClientSecret:
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		case r.PostForm.Get("code") != testCode || r.PostForm.Get("code_verifier") != testVerifier:
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		default:
+			json.NewEncoder(w).Encode(map[string]string{"id_token": ti.idToken(ti.URL)})
+		}
+	})
+	ti.Server = httptest.NewServer(mux)
+	t.Cleanup(ti.Close)
+	return ti
+}
+
+// 使用指定私钥签发 RS256 id_token
+func signToken(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func validClaims(iss string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":                iss,
+		"sub":                "user-1",
+		"aud":                testClientID,
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"iat":                now.Unix(),
+		"nonce":              testNonce,
+		"preferred_username": "alice",
+	}
+}
+
+func newTestProvider(ti *testIssuer) *Provider {
+	return NewProvider(Config{
+		Issuer:       ti.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  "http://127.0.0.1/api/auth/oidc/callback",
+	})
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	ti := newTestIssuer(t)
+	u, err := newTestProvider(ti).AuthCodeURL("state-1", testNonce, testVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := parsed.Query()
+	if !strings.HasPrefix(u, ti.URL+"/authorize?") {
+		t.Errorf("授权地址错误: %s", u)
+	}
+	if q.Get("state") != "state-1" || q.Get("nonce") != testNonce || q.Get("client_id") != testClientID {
+		t.Errorf("授权参数错误: %v", q)
+	}
+	if q.Get("code_challenge") != PKCEChallenge(testVerifier) || q.Get("code_challenge_method") != "S256" {
+		t.Errorf("PKCE参数错误: %v", q)
+	}
+}
+
+func TestExchangeAndVerify(t *testing.T) {
+	ti := newTestIssuer(t)
+	ti.idToken = func(iss string) string {
+		return signToken(t, ti.key, testKid, validClaims(iss))
+	}
+	p := newTestProvider(ti)
+
+	idToken, err := p.Exchange(testCode, testVerifier)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	claims, err := p.VerifyIDToken(idToken, testNonce)
+	if err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+	if claims["preferred_username"] != "alice" {
+		t.Errorf("claims错误: %v", claims)
+	}
+}
+
+func TestExchangeErrors(t *testing.T) {
+	ti := newTestIssuer(t)
+	ti.idToken = func(iss string) string {
+		return signToken(t, ti.key, testKid, validClaims(iss))
+	}
+
+	if _, err := newTestProvider(ti).Exchange("wrong-code", testVerifier); err == nil {
+		t.Error("错误的授权码应当失败")
+	}
+	if _, err := newTestProvider(ti).Exchange(testCode, "wrong-verifier"); err == nil {
+		t.Error("错误的 code_verifier 应当失败")
+	}
+	p := NewProvider(Config{Issuer: ti.URL, ClientID: testClientID, ClientSecret: "wrong"})
+	if _, err := p.Exchange(testCode, testVerifier); err == nil {
+		t.Error("错误的 client_secret 应当失败")
+	}
+}
+
+func TestVerifyIDTokenRejects(t *testing.T) {
+	ti := newTestIssuer(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		key    *rsa.PrivateKey
+		modify func(claims map[string]interface{})
+		nonce  string
+		want   error
+	}{
+		{name: "nonce不一致", nonce: "other-nonce", want: ErrInvalidToken},
+		{name: "缺少nonce", modify: func(c map[string]interface{}) { delete(c, "nonce") }, want: ErrInvalidToken},
+		{name: "aud不一致", modify: func(c map[string]interface{}) { c["aud"] = "other-client" }, want: ErrInvalidToken},
+		{name: "aud数组中没有client_id", modify: func(c map[string]interface{}) { c["aud"] = []string{"a", "b"} }, want: ErrInvalidToken},
+		{name: "issuer不一致", modify: func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }, want: ErrInvalidToken},
+		{name: "已过期", modify: func(c map[string]interface{}) { c["exp"] = time.Now().Add(-2 * clockSkew).Unix() }, want: ErrExpiredToken},
+		{name: "缺少exp", modify: func(c map[string]interface{}) { delete(c, "exp") }, want: ErrExpiredToken},
+		{name: "签名错误", key: otherKey, want: ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims(ti.URL)
+			if tt.modify != nil {
+				tt.modify(claims)
+			}
+			key := ti.key
+			if tt.key != nil {
+				key = tt.key
+			}
+			nonce := testNonce
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+
+			_, err := newTestProvider(ti).VerifyIDToken(signToken(t, key, testKid, claims), nonce)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("期望错误 %v,实际 %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenMalformed(t *testing.T) {
+	ti := newTestIssuer(t)
+	p := newTestProvider(ti)
+	valid := signToken(t, ti.key, testKid, validClaims(ti.URL))
+	parts := strings.Split(valid, ".")
+
+	for name, raw := range map[string]string{
+		"不是JWT":      "abc",
+		"签名被截断":      parts[0] + "." + parts[1] + ".",
+		"payload被修改": parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"aud":"xuanwu"}`)) + "." + parts[2],
+		"alg为none":   base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"test-key"}`)) + "." + parts[1] + ".",
+	} {
+		if _, err := p.VerifyIDToken(raw, testNonce); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: 期望 ErrInvalidToken,实际 %v", name, err)
+		}
+	}
+}
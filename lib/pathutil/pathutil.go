@@ -3,18 +3,27 @@ package pathutil
 import (
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 )
 
 const (
-	DATA_DIR    = "data"
-	LOG_DIR     = "logs"
-	CONFIG_FILE = "config.json"
-	ENV_FILE    = "env.ini"
+	DATA_DIR      = "data"
+	LOG_DIR       = "logs"
+	CONFIG_FILE   = "config.json"
+	ENV_FILE      = "env.ini"
+	INSTANCE_LOCK = "xuanwu.lock"
+	CONFIG_LOCK   = ".config.lock"
+	SECRET_FILE   = ".secret"
+	TLS_DIR       = "tls"
+	UI_DIR        = "ui"
+	APP_NAME      = "xuanwu"
 )
 
 var (
 	executablePath string
 	rootDir        string
+	dataDir        string
 )
 
 func init() {
@@ -23,7 +32,89 @@ func init() {
 	if err != nil {
 		panic("无法获取可执行文件路径: " + err.Error())
 	}
+	if p, err := filepath.EvalSymlinks(executablePath); err == nil {
+		executablePath = p
+	}
 	rootDir = filepath.Dir(executablePath)
+	dataDir = defaultDataDir()
+}
+
+// 默认数据目录
+// 优先级: 环境变量 XW_DATA_DIR > XW_HOME/data > 系统安装时的XDG目录 > 程序目录/data
+func defaultDataDir() string {
+	if dir := os.Getenv("XW_DATA_DIR"); dir != "" {
+		return absPath(dir)
+	}
+	if home := os.Getenv("XW_HOME"); home != "" {
+		return absPath(filepath.Join(home, DATA_DIR))
+	}
+	if dir := systemDataDir(); dir != "" {
+		return dir
+	}
+	return filepath.Join(rootDir, DATA_DIR)
+}
+
+// 程序安装在系统目录时(如/usr/bin),数据不能放在程序旁边
+// 旧版本已在程序目录下创建了data时继续使用,升级后不会变成一个全新的数据目录
+func systemDataDir() string {
+	if info, err := os.Stat(filepath.Join(rootDir, DATA_DIR)); err == nil && info.IsDir() {
+		return ""
+	}
+	if runtime.GOOS == "windows" {
+		programFiles := os.Getenv("ProgramFiles")
+		if programFiles == "" || !isSubPath(programFiles, rootDir) {
+			return ""
+		}
+		if dir := os.Getenv("LOCALAPPDATA"); dir != "" {
+			return filepath.Join(dir, APP_NAME)
+		}
+		return ""
+	}
+
+	isSystem := false
+	for _, dir := range []string{"/usr/bin", "/usr/sbin", "/usr/local/bin", "/usr/local/sbin", "/bin", "/sbin"} {
+		if rootDir == dir {
+			isSystem = true
+			break
+		}
+	}
+	if !isSystem {
+		return ""
+	}
+	// root用户使用/var/lib,普通用户使用XDG数据目录
+	if os.Getuid() == 0 {
+		return filepath.Join("/var/lib", APP_NAME)
+	}
+	if dir := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, APP_NAME)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".local", "share", APP_NAME)
+	}
+	return ""
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// 判断path是否在dir内
+func isSubPath(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// SetDataDir 设置数据目录,命令行参数 --data-dir 使用
+func SetDataDir(dir string) {
+	if dir != "" {
+		dataDir = absPath(dir)
+	}
 }
 
 // GetExecutablePath 获取可执行文件路径
@@ -36,24 +127,39 @@ func GetRootDir() string {
 	return rootDir
 }
 
+// GetDataDir 获取数据目录
+func GetDataDir() string {
+	return dataDir
+}
+
+// IsInDataDir 检查路径是否在数据目录内
+func IsInDataDir(path string) bool {
+	return isSubPath(dataDir, path)
+}
+
 // GetDataPath 获取数据目录下的路径
 func GetDataPath(subPath string) string {
-	return filepath.Join(rootDir, DATA_DIR, subPath)
+	return filepath.Join(dataDir, subPath)
 }
 
 // GetLogPath 获取日志文件路径
 func GetLogPath(filename string) string {
-	return filepath.Join(rootDir, DATA_DIR, LOG_DIR, filename)
+	return filepath.Join(dataDir, LOG_DIR, filename)
 }
 
 // GetConfigPath 获取配置文件路径
 func GetConfigPath() string {
-	return filepath.Join(rootDir, DATA_DIR, CONFIG_FILE)
+	return filepath.Join(dataDir, CONFIG_FILE)
 }
 
 // GetEnvPath 获取环境变量文件路径
 func GetEnvPath() string {
-	return filepath.Join(rootDir, DATA_DIR, ENV_FILE)
+	return filepath.Join(dataDir, ENV_FILE)
+}
+
+// GetInstanceLockPath 获取单实例锁文件路径,文件内容为服务进程号
+func GetInstanceLockPath() string {
+	return filepath.Join(dataDir, INSTANCE_LOCK)
 }
 
 // EnsureDir 确保目录存在
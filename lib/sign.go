@@ -0,0 +1,173 @@
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"xuanwu/lib/pathutil"
+)
+
+// 签名密钥长度
+const secretLen = 32
+
+// 允许的时钟误差
+const tokenClockSkew = time.Minute
+
+var (
+	ErrTokenInvalid = errors.New("token无效")
+	ErrTokenExpired = errors.New("token已过期")
+)
+
+// TokenClaims token中保存的信息
+type TokenClaims struct {
+	User string `json:"u"`
+	ID   string `json:"jti"` // 随机ID,用于区分同一用户的多个会话
+	Iat  int64  `json:"iat"` // 签发时间
+	Exp  int64  `json:"exp"` // 过期时间
+}
+
+var (
+	secret     []byte
+	secretLock sync.RWMutex
+)
+
+// 读取签名密钥,不存在时生成并保存到数据目录
+func getSecret() ([]byte, error) {
+	secretLock.RLock()
+	if secret != nil {
+		defer secretLock.RUnlock()
+		return secret, nil
+	}
+	secretLock.RUnlock()
+
+	secretLock.Lock()
+	defer secretLock.Unlock()
+	if secret != nil {
+		return secret, nil
+	}
+
+	path := pathutil.GetDataPath(pathutil.SECRET_FILE)
+	if data, err := os.ReadFile(path); err == nil {
+		if key, err := hex.DecodeString(strings.TrimSpace(string(data))); err == nil && len(key) >= secretLen {
+			secret = key
+			return secret, nil
+		}
+	}
+	key, err := writeSecret(path)
+	if err != nil {
+		return nil, err
+	}
+	secret = key
+	return secret, nil
+}
+
+// 生成新密钥并写入文件,只允许当前用户读写
+func writeSecret(path string) ([]byte, error) {
+	key := make([]byte, secretLen)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := pathutil.EnsureDir(pathutil.GetDataPath("")); err != nil {
+		return nil, err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(hex.EncodeToString(key)), 0600); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	return key, nil
+}
+
+// RotateSecret 重新生成签名密钥,之前签发的token全部失效
+func RotateSecret() error {
+	secretLock.Lock()
+	defer secretLock.Unlock()
+	key, err := writeSecret(pathutil.GetDataPath(pathutil.SECRET_FILE))
+	if err != nil {
+		return err
+	}
+	secret = key
+	return nil
+}
+
+// ResetSecretCache 清除内存中的密钥,下次使用时从文件重新读取
+func ResetSecretCache() {
+	secretLock.Lock()
+	secret = nil
+	secretLock.Unlock()
+}
+
+// RandomString 生成指定字节数的随机字符串(hex编码)
+func RandomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// SignToken 签发token,格式为 base64(claims).base64(HMAC-SHA256)
+func SignToken(user string, ttl time.Duration) (string, *TokenClaims, error) {
+	key, err := getSecret()
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	claims := &TokenClaims{
+		User: user,
+		ID:   RandomString(12),
+		Iat:  now.Unix(),
+		Exp:  now.Add(ttl).Unix(),
+	}
+	payload, _ := json.Marshal(claims)
+	body := base64.RawURLEncoding.EncodeToString(payload)
+	return body + "." + sign(key, body), claims, nil
+}
+
+// ParseToken 校验签名、签发时间和过期时间
+func ParseToken(token string) (*TokenClaims, error) {
+	body, sig, ok := strings.Cut(token, ".")
+	if !ok || body == "" || sig == "" {
+		return nil, ErrTokenInvalid
+	}
+	key, err := getSecret()
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal([]byte(sig), []byte(sign(key, body))) {
+		return nil, ErrTokenInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+	var claims TokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.User == "" {
+		return nil, ErrTokenInvalid
+	}
+
+	now := time.Now()
+	if claims.Iat > now.Add(tokenClockSkew).Unix() {
+		return nil, ErrTokenInvalid
+	}
+	if claims.Exp <= now.Unix() {
+		return nil, ErrTokenExpired
+	}
+	return &claims, nil
+}
+
+func sign(key []byte, body string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(body))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,98 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+	"xuanwu/lib/pathutil"
+)
+
+const (
+	CERT_FILE = "cert.pem"
+	KEY_FILE  = "key.pem"
+
+	selfSignedValidity = 10 * 365 * 24 * time.Hour
+)
+
+// SelfSignedPaths 自签名证书的保存位置
+func SelfSignedPaths() (string, string) {
+	dir := pathutil.GetDataPath(pathutil.TLS_DIR)
+	return filepath.Join(dir, CERT_FILE), filepath.Join(dir, KEY_FILE)
+}
+
+// EnsureSelfSigned 证书不存在时生成自签名证书并保存,返回证书和私钥路径
+func EnsureSelfSigned() (string, string, bool, error) {
+	certPath, keyPath := SelfSignedPaths()
+	if _, err := tls.LoadX509KeyPair(certPath, keyPath); err == nil {
+		return certPath, keyPath, false, nil
+	}
+	if err := generate(certPath, keyPath); err != nil {
+		return "", "", false, err
+	}
+	return certPath, keyPath, true, nil
+}
+
+// 生成ECDSA自签名证书,包含本机主机名和IP
+func generate(certPath, keyPath string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	dnsNames := []string{"localhost"}
+	if host, err := os.Hostname(); err == nil && host != "" && host != "localhost" {
+		dnsNames = append(dnsNames, host)
+	}
+	ips := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+				ips = append(ips, ipNet.IP)
+			}
+		}
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: pathutil.APP_NAME, Organization: []string{pathutil.APP_NAME}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              dnsNames,
+		IPAddresses:           ips,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("生成证书失败: %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := pathutil.EnsureDir(filepath.Dir(certPath)); err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
@@ -6,11 +6,15 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
+	"xuanwu/cli"
 	"xuanwu/config"
 	serve "xuanwu/gin"
+	"xuanwu/lib/flock"
+	"xuanwu/lib/pathutil"
 	xwlog "xuanwu/log"
 	"xuanwu/xuanwu"
 )
@@ -18,6 +22,9 @@ import (
 // 添加Windows命令行参数
 var hideWindow = flag.Bool("hide", false, "在Windows平台下隐藏命令提示符窗口")
 
+// 数据目录,默认使用环境变量 XW_DATA_DIR 或程序目录下的data
+var dataDir = flag.String("data-dir", "", "数据目录")
+
 func init() {
 	if !config.IsWindows { //windows上设置时区会报错,不设置也会正常显示,linux日志时间会差8小时
 		TIME_LOCATION, err := time.LoadLocation("Asia/Shanghai")
@@ -30,17 +37,36 @@ func init() {
 }
 
 func main() {
+	flag.Parse()
+	pathutil.SetDataDir(*dataDir)
+
+	// 带子命令时执行命令行操作后退出
+	if flag.NArg() > 0 {
+		os.Exit(cli.Run(flag.Args()))
+	}
+
 	// 监听系统信号
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-	flag.Parse()
+	reloadChan := make(chan os.Signal, 1)
+	notifyReload(reloadChan)
 
 	// Windows平台特定逻辑
 	if config.IsWindows && *hideWindow {
 		hideConsoleWindow()
 	}
 
+	// 单实例锁,同一数据目录只允许运行一个服务
+	instanceLock, err := flock.Acquire(pathutil.GetInstanceLockPath(), 0)
+	if err != nil {
+		fmt.Printf("数据目录 %s 已被其他玄武实例使用\n", pathutil.GetDataDir())
+		os.Exit(1)
+	}
+	defer instanceLock.Release()
+	// 写入进程号,供命令行通知重新加载配置
+	instanceLock.File().Truncate(0)
+	instanceLock.File().WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+
 	//初始化日志文件
 	_, Writer := xwlog.LogInit("main.log")
 	log.SetOutput(Writer) // 设置默认logger
@@ -52,7 +78,8 @@ func main() {
 
 	cfg, err := config.ReadConfigFileToJson()
 	if err != nil {
-		log.Println("读取配置文件出错")
+		log.Printf("读取配置文件出错: %v", err)
+		fmt.Println("读取配置文件出错:", err)
 		return
 	}
 	fmt.Println("玄武启动，版本：v" + config.Version + "，按 Ctrl+C 退出")
@@ -67,5 +94,13 @@ func main() {
 	fmt.Println(time.Now())
 	log.Println("玄武启动，版本：v" + config.Version)
 
-	<-sigChan
+	for {
+		select {
+		case <-reloadChan:
+			log.Println("收到重新加载信号")
+			serve.ReloadConfig()
+		case <-sigChan:
+			return
+		}
+	}
 }
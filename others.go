@@ -2,7 +2,18 @@
 
 package main
 
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
 // 非Windows平台的空实现
 func hideConsoleWindow() {
 	// 什么都不做
-}
\ No newline at end of file
+}
+
+// 收到SIGHUP时重新加载配置
+func notifyReload(c chan os.Signal) {
+	signal.Notify(c, syscall.SIGHUP)
+}
@@ -2,7 +2,10 @@
 
 package main
 
-import "syscall"
+import (
+	"os"
+	"syscall"
+)
 
 func hideConsoleWindow() {
 	kernel32 := syscall.NewLazyDLL("kernel32.dll")
@@ -18,4 +21,7 @@ func hideConsoleWindow() {
 	// 释放控制台
 	freeConsole := kernel32.NewProc("FreeConsole")
 	freeConsole.Call()
-}
\ No newline at end of file
+}
+
+// Windows不支持SIGHUP,命令行修改后需重启服务
+func notifyReload(c chan os.Signal) {}
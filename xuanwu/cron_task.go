@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"sync"
+	"sync/atomic"
+	"time"
 	xwlog "xuanwu/log"
 
 	"github.com/robfig/cron/v3"
@@ -13,6 +16,9 @@ import (
 // 定义全局定时任务
 var C *cron.Cron
 
+// 调度器是否已启动
+var running atomic.Bool
+
 // 任务信息结构体
 type TaskInfo struct {
 	Name        string   `json:"name"`
@@ -27,16 +33,78 @@ type TaskInfo struct {
 	Callback    string
 }
 
-// 定时id和任务的映射表
-var TaskData = map[cron.EntryID]TaskInfo{}
+// 定时表达式解析器,秒字段可选
+var Parser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// 定时id和任务的映射表,读写都需要持有 taskLock
+var (
+	TaskData = map[cron.EntryID]TaskInfo{}
+	taskLock sync.RWMutex
+)
 
 // 定时任务
 func CronInit(cfg gjson.Result) {
-	tasks := cfg.Get("task")
-	C = cron.New(
-		cron.WithParser(cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)))
+	C = cron.New(cron.WithParser(Parser))
+
+	taskLock.Lock()
+	addUserTasks(cfg) //添加用户自定义任务
+	taskLock.Unlock()
+
+	// 遍历系统任务切片中的每一项
+	initSystemTask(cfg)
+	for _, item := range SystemTask {
+		if !item.Enable { //启动时候是否执行
+			continue
+		}
+		AddRunFunc(item)
+	}
 
-	tasks.ForEach(func(key, value gjson.Result) bool { //添加用户自定义任务
+	C.Start()
+	running.Store(true)
+	defer C.Stop()
+	select {}
+}
+
+// 正在进行的调度器检查,调度循环卡住时检查会一直阻塞,后续调用复用同一个检查
+var (
+	probeMu   sync.Mutex
+	probeDone chan struct{}
+)
+
+// SchedulerAlive 调度器是否在运行,运行中获取任务列表需要调度循环响应,超时视为卡住
+// 同一时间最多只有一个检查协程,调度器卡住时不会因为反复探测而堆积协程
+func SchedulerAlive(timeout time.Duration) bool {
+	if !running.Load() {
+		return false
+	}
+	probeMu.Lock()
+	done := probeDone
+	if done == nil {
+		done = make(chan struct{})
+		probeDone = done
+		go func() {
+			C.Entries()
+			probeMu.Lock()
+			probeDone = nil
+			probeMu.Unlock()
+			close(done)
+		}()
+	}
+	probeMu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// 添加配置中已启用的用户任务,调用方需持有 taskLock
+func addUserTasks(cfg gjson.Result) {
+	cfg.Get("task").ForEach(func(key, value gjson.Result) bool {
 		enable := value.Get("enable").Bool()
 		if !enable { //启动时候是否执行
 			return true
@@ -53,21 +121,58 @@ func CronInit(cfg gjson.Result) {
 			WorkDir: value.Get("workdir").String(),
 			Exec: value.Get("exec").String(),
 		}
-		AddRunFunc(TaskData)
+		addRunFunc(TaskData)
 		return true
 	})
+}
 
-	// 遍历系统任务切片中的每一项
-	for _, item := range SystemTask {
-		if !item.Enable { //启动时候是否执行
+// ReloadTasks 按配置重新加载全部用户任务,系统任务不变
+func ReloadTasks(cfg gjson.Result) {
+	taskLock.Lock()
+	defer taskLock.Unlock()
+	for _, e := range C.Entries() {
+		taskInfo, exists := TaskData[e.ID]
+		if !exists || taskInfo.System {
 			continue
 		}
-		AddRunFunc(item)
+		removeEntry(e.ID, taskInfo)
 	}
+	addUserTasks(cfg)
+}
 
-	C.Start()
-	defer C.Stop()
-	select {}
+// RemoveTask 从调度器中移除指定名称的任务
+func RemoveTask(name string) {
+	taskLock.Lock()
+	defer taskLock.Unlock()
+	for _, e := range C.Entries() {
+		if taskInfo, exists := TaskData[e.ID]; exists && taskInfo.Name == name {
+			removeEntry(e.ID, taskInfo)
+		}
+	}
+}
+
+// RunningTasks 调度器中的任务名称和定时id
+func RunningTasks() map[string]cron.EntryID {
+	taskLock.RLock()
+	defer taskLock.RUnlock()
+	list := make(map[string]cron.EntryID, len(TaskData))
+	for id, task := range TaskData {
+		list[task.Name] = id
+	}
+	return list
+}
+
+// 移除定时并关闭任务日志,调用方需持有 taskLock
+func removeEntry(id cron.EntryID, taskInfo TaskInfo) {
+	C.Remove(id)
+	// 关闭日志文件
+	if taskInfo.Writer != nil {
+		taskInfo.Writer.Close()
+	}
+	// 停止写入日志
+	taskInfo.Log.SetOutput(io.Discard)
+	// 从映射表中删除
+	delete(TaskData, id)
 }
 
 /* 根据任务类型,添加任务
@@ -77,6 +182,13 @@ func CronInit(cfg gjson.Result) {
 * workDir 工作目录
  */
 func AddRunFunc(TaskInfo TaskInfo) {
+	taskLock.Lock()
+	defer taskLock.Unlock()
+	addRunFunc(TaskInfo)
+}
+
+// 添加任务,调用方需持有 taskLock
+func addRunFunc(TaskInfo TaskInfo) {
 	logname := fmt.Sprintf("%s.log", TaskInfo.Name)
 	if TaskInfo.System {
 		logname = ""
@@ -99,7 +211,7 @@ func AddRunFunc(TaskInfo TaskInfo) {
 		} else {
 			// 普通任务执行命令
 			id, err = C.AddFunc(timeStr, func() {
-				if err := ExecTask(TaskInfo.Exec, TaskInfo.WorkDir, log); err != nil {
+				if err := RunTask(TaskInfo.Name, TaskInfo.Exec, TaskInfo.WorkDir, log, TRIGGER_CRON); err != nil {
 					log.Printf("任务执行失败: %v\n", err)
 				}
 			})
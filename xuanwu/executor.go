@@ -138,6 +138,39 @@ func newEncodingScanner(reader io.Reader) *bufio.Scanner {
 	return scanner
 }
 
+// 任务触发方式
+const (
+	TRIGGER_CRON   = "cron"
+	TRIGGER_MANUAL = "manual"
+	TRIGGER_CLI    = "cli"
+)
+
+// RunTask 执行任务并保存运行记录
+func RunTask(name string, command string, workDir string, logger *log.Logger, trigger string) error {
+	done := trackRun(name, trigger)
+	startTime := time.Now()
+	err := ExecTask(command, workDir, logger)
+	endTime := time.Now()
+	done(err)
+
+	rec := config.RunRecord{
+		Name:     name,
+		Exec:     command,
+		Trigger:  trigger,
+		Start:    startTime,
+		End:      endTime,
+		Duration: endTime.Sub(startTime).Milliseconds(),
+		Success:  err == nil,
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	if e := config.GetStore().AddRun(rec); e != nil {
+		log.Printf("保存运行记录失败[%s]: %v", name, e)
+	}
+	return err
+}
+
 // 执行任务命令
 func ExecTask(command string, workDir string, logger *log.Logger) error {
 	// 记录开始时间
@@ -0,0 +1,52 @@
+package xuanwu
+
+import (
+	"time"
+	"xuanwu/lib/metrics"
+)
+
+// 任务运行的统计指标
+var (
+	TaskRuns = metrics.NewCounterVec("xuanwu_task_runs_total",
+		"任务运行次数", "task", "result", "trigger")
+	TaskDuration = metrics.NewHistogramVec("xuanwu_task_run_duration_seconds",
+		"任务运行用时",
+		[]float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 600, 1800, 3600}, "task")
+	TaskRunning = metrics.NewGaugeVec("xuanwu_task_running",
+		"正在运行的任务实例数", "task")
+)
+
+// 记录任务开始运行,返回结束时调用的函数
+func trackRun(name, trigger string) func(err error) {
+	start := time.Now()
+	TaskRunning.Add(1, name)
+	return func(err error) {
+		TaskRunning.Add(-1, name)
+		result := "success"
+		if err != nil {
+			result = "failure"
+		}
+		TaskRuns.Inc(name, result, trigger)
+		TaskDuration.Observe(time.Since(start).Seconds(), name)
+	}
+}
+
+// TaskNextRuns 每个任务下次运行的时间,有多个定时时取最早的
+func TaskNextRuns() map[string]time.Time {
+	next := map[string]time.Time{}
+	if !running.Load() {
+		return next
+	}
+	taskLock.RLock()
+	defer taskLock.RUnlock()
+	for _, e := range C.Entries() {
+		info, ok := TaskData[e.ID]
+		if !ok || e.Next.IsZero() {
+			continue
+		}
+		if t, exists := next[info.Name]; !exists || e.Next.Before(t) {
+			next[info.Name] = e.Next
+		}
+	}
+	return next
+}
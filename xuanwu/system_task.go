@@ -3,8 +3,12 @@ package xuanwu
 import (
 	"log"
 	"sync"
+	"time"
 	"xuanwu/config"
+	"xuanwu/lib/backup"
 	xwlog "xuanwu/log"
+
+	"github.com/tidwall/gjson"
 )
 
 var (
@@ -12,15 +16,6 @@ var (
 	logCleanLock sync.RWMutex
 )
 
-func init() {
-	// 初始化日志清理天数
-	if cfg, err := config.ReadConfigFileToJson(); err == nil {
-		if days := cfg.Get("log_clean_days").Int(); days > 0 {
-			logCleanDays = int(days)
-		}
-	}
-}
-
 // 系统任务
 var SystemTask = []TaskInfo{
 	{
@@ -32,6 +27,34 @@ var SystemTask = []TaskInfo{
 		Enable:  true,
 		Func:    cleanLogsTask,
 	},
+	{
+		Name:    "定时备份",
+		Times:   []string{"@daily"},
+		WorkDir: "",
+		Exec:    "",
+		System:  true,
+		Enable:  false, // 由配置 backup.enable 开启
+		Func:    backupTask,
+	},
+}
+
+// 根据配置设置系统任务
+func initSystemTask(cfg gjson.Result) {
+	// 初始化日志清理天数
+	UpdateLogCleanDays(int(cfg.Get("log_clean_days").Int()))
+
+	for i := range SystemTask {
+		if SystemTask[i].Name != "定时备份" {
+			continue
+		}
+		SystemTask[i].Enable = cfg.Get("backup.enable").Bool()
+		if times := cfg.Get("backup.times").Array(); len(times) > 0 {
+			SystemTask[i].Times = nil
+			for _, t := range times {
+				SystemTask[i].Times = append(SystemTask[i].Times, t.String())
+			}
+		}
+	}
 }
 
 // UpdateLogCleanDays 更新日志清理天数
@@ -58,4 +81,50 @@ func cleanLogsTask() {
 		log.Printf("清理日志失败: %v", err)
 		return
 	}
+
+	// 运行记录使用同样的保留天数
+	if err := config.GetStore().PruneRuns(time.Now().AddDate(0, 0, -days)); err != nil {
+		log.Printf("清理运行记录失败: %v", err)
+	}
+
+	// 审计记录默认永久保留,只有设置了 audit_retention_days 才清理
+	cfg, err := config.ReadConfigFileToJson()
+	if err != nil {
+		return
+	}
+	if auditDays := cfg.Get("audit_retention_days").Int(); auditDays > 0 {
+		if err := config.GetStore().PruneAudit(time.Now().AddDate(0, 0, -int(auditDays))); err != nil {
+			log.Printf("清理审计记录失败: %v", err)
+		}
+	}
+}
+
+// backupTask 定时备份数据目录并清理旧备份
+func backupTask() {
+	log.Printf("定时备份")
+
+	cfg, err := config.ReadConfigFileToJson()
+	if err != nil {
+		log.Printf("定时备份失败,读取配置出错: %v", err)
+		return
+	}
+
+	opts := backup.Options{
+		Format:      cfg.Get("backup.format").String(),
+		ExcludeLogs: !cfg.Get("backup.exclude_logs").Exists() || cfg.Get("backup.exclude_logs").Bool(),
+	}
+	path, err := backup.CreateFile(opts)
+	if err != nil {
+		log.Printf("定时备份失败: %v", err)
+		return
+	}
+	log.Printf("备份完成: %s", path)
+
+	keep := int(cfg.Get("backup.keep").Int())
+	if keep <= 0 {
+		keep = 7
+	}
+	if err := backup.Prune(keep); err != nil {
+		log.Printf("清理旧备份失败: %v", err)
+	}
 }